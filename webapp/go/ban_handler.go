@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// 配信者の全配信からのBANは、user_bans.livestream_idをこの値にして登録する
+const streamerBanLivestreamID = 0
+
+type UserBanModel struct {
+	ID           int64         `db:"id"`
+	StreamerID   int64         `db:"streamer_id"`
+	LivestreamID int64         `db:"livestream_id"`
+	UserID       int64         `db:"user_id"`
+	Reason       string        `db:"reason"`
+	ExpiresAt    sql.NullInt64 `db:"expires_at"`
+	CreatedBy    int64         `db:"created_by"`
+	CreatedAt    int64         `db:"created_at"`
+}
+
+type UserBan struct {
+	ID int64 `json:"id"`
+	// 配信者の全配信からのBANなら0
+	LivestreamID int64  `json:"livestream_id"`
+	User         User   `json:"user"`
+	Reason       string `json:"reason"`
+	// タイムアウトの期限。無期限のBANでは省略
+	ExpiresAt *int64 `json:"expires_at,omitempty"`
+	CreatedBy int64  `json:"created_by"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type PutUserBanRequest struct {
+	Reason string `json:"reason"`
+	// タイムアウトの秒数。0または省略で無期限のBAN
+	DurationSeconds int64 `json:"duration_seconds"`
+}
+
+// (配信者・モデレーター向け)ライブ配信のBAN一覧取得API
+// GET /api/livestream/:livestream_id/ban
+func getLivestreamBansHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	bans, err := getActiveBans(ctx, tx, livestreamModel.UserID, livestreamModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get bans: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, bans)
+}
+
+// (配信者・モデレーター向け)ライブ配信からのBAN・タイムアウトAPI
+// PUT /api/livestream/:livestream_id/ban/:username
+func putLivestreamBanHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var req *PutUserBanRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	ban, err := banUser(ctx, tx, livestreamModel.UserID, livestreamModel.ID, c.Param("username"), req, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, ban)
+}
+
+// (配信者・モデレーター向け)ライブ配信からのBAN解除API
+// DELETE /api/livestream/:livestream_id/ban/:username
+func deleteLivestreamBanHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	if err := unbanUser(ctx, tx, livestreamModel.UserID, livestreamModel.ID, c.Param("username"), userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// (配信者向け)全配信からのBAN一覧取得API
+// GET /api/user/me/ban
+func getStreamerBansHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	bans, err := getActiveBans(ctx, tx, userID, streamerBanLivestreamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get bans: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, bans)
+}
+
+// (配信者向け)全配信からのBAN・タイムアウトAPI
+// PUT /api/user/me/ban/:username
+func putStreamerBanHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *PutUserBanRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	ban, err := banUser(ctx, tx, userID, streamerBanLivestreamID, c.Param("username"), req, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, ban)
+}
+
+// (配信者向け)全配信からのBAN解除API
+// DELETE /api/user/me/ban/:username
+func deleteStreamerBanHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	if err := unbanUser(ctx, tx, userID, streamerBanLivestreamID, c.Param("username"), userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// verifyNotBanned はユーザがこの配信、または配信者の全配信からBAN・タイムアウトされていればエラーを返す
+// 配信が存在しない場合は何もしない (呼び出し元の従来のエラーに任せる)
+func verifyNotBanned(ctx context.Context, tx *sqlx.Tx, livestreamID int64, userID int64) error {
+	query := `
+	SELECT b.* FROM user_bans b
+	INNER JOIN livestreams l ON l.user_id = b.streamer_id
+	WHERE l.id = ? AND b.user_id = ? AND b.livestream_id IN (?, l.id) AND (b.expires_at IS NULL OR b.expires_at > ?)
+	ORDER BY b.expires_at IS NULL DESC, b.expires_at DESC
+	LIMIT 1
+	`
+	var banModel UserBanModel
+	if err := tx.GetContext(ctx, &banModel, query, livestreamID, userID, streamerBanLivestreamID, time.Now().Unix()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get bans: "+err.Error())
+	}
+
+	if banModel.ExpiresAt.Valid {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("you are timed out from this livestream until %d", banModel.ExpiresAt.Int64))
+	}
+	return echo.NewHTTPError(http.StatusForbidden, "you are banned from this livestream")
+}
+
+func getActiveBans(ctx context.Context, tx *sqlx.Tx, streamerID int64, livestreamID int64) ([]UserBan, error) {
+	var banModels []UserBanModel
+	if err := tx.SelectContext(ctx, &banModels, "SELECT * FROM user_bans WHERE streamer_id = ? AND livestream_id = ? AND (expires_at IS NULL OR expires_at > ?) ORDER BY created_at DESC, id DESC", streamerID, livestreamID, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+
+	bans := make([]UserBan, len(banModels))
+	for i := range banModels {
+		ban, err := fillUserBanResponse(ctx, tx, banModels[i])
+		if err != nil {
+			return nil, err
+		}
+		bans[i] = ban
+	}
+	return bans, nil
+}
+
+// banUser はBANを登録する。既にBANされていれば理由と期限を上書きする
+func banUser(ctx context.Context, tx *sqlx.Tx, streamerID int64, livestreamID int64, username string, req *PutUserBanRequest, bannedBy int64) (UserBan, error) {
+	if req.DurationSeconds < 0 {
+		return UserBan{}, echo.NewHTTPError(http.StatusBadRequest, "duration_seconds must not be negative")
+	}
+
+	var userModel UserModel
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserBan{}, echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+		}
+		return UserBan{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+	if userModel.ID == streamerID {
+		return UserBan{}, echo.NewHTTPError(http.StatusBadRequest, "a streamer can't be banned from own livestreams")
+	}
+
+	now := time.Now().Unix()
+	banModel := UserBanModel{
+		StreamerID:   streamerID,
+		LivestreamID: livestreamID,
+		UserID:       userModel.ID,
+		Reason:       req.Reason,
+		CreatedBy:    bannedBy,
+		CreatedAt:    now,
+	}
+	if req.DurationSeconds > 0 {
+		banModel.ExpiresAt = sql.NullInt64{Int64: now + req.DurationSeconds, Valid: true}
+	}
+
+	query := `
+	INSERT INTO user_bans (streamer_id, livestream_id, user_id, reason, expires_at, created_by, created_at)
+	VALUES (:streamer_id, :livestream_id, :user_id, :reason, :expires_at, :created_by, :created_at)
+	ON DUPLICATE KEY UPDATE
+		reason = VALUES(reason),
+		expires_at = VALUES(expires_at),
+		created_by = VALUES(created_by),
+		created_at = VALUES(created_at)
+	`
+	if _, err := tx.NamedExecContext(ctx, query, banModel); err != nil {
+		return UserBan{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to insert ban: "+err.Error())
+	}
+	detail := map[string]interface{}{
+		"reason": banModel.Reason,
+	}
+	// 期限なしのBANではexpires_atを付けない
+	if banModel.ExpiresAt.Valid {
+		detail["expires_at"] = banModel.ExpiresAt.Int64
+	}
+	if err := recordModerationEvent(ctx, tx, streamerID, livestreamID, bannedBy, moderationActionUserBanned, moderationTargetUser, userModel.ID, detail); err != nil {
+		return UserBan{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+
+	if err := tx.GetContext(ctx, &banModel, "SELECT * FROM user_bans WHERE streamer_id = ? AND livestream_id = ? AND user_id = ?", streamerID, livestreamID, userModel.ID); err != nil {
+		return UserBan{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get ban: "+err.Error())
+	}
+	ban, err := fillUserBanResponse(ctx, tx, banModel)
+	if err != nil {
+		return UserBan{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to fill ban: "+err.Error())
+	}
+	return ban, nil
+}
+
+func unbanUser(ctx context.Context, tx *sqlx.Tx, streamerID int64, livestreamID int64, username string, unbannedBy int64) error {
+	var userModel UserModel
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+
+	rs, err := tx.ExecContext(ctx, "DELETE FROM user_bans WHERE streamer_id = ? AND livestream_id = ? AND user_id = ?", streamerID, livestreamID, userModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete ban: "+err.Error())
+	}
+	if n, err := rs.RowsAffected(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get affected rows: "+err.Error())
+	} else if n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "ban not found")
+	}
+	if err := recordModerationEvent(ctx, tx, streamerID, livestreamID, unbannedBy, moderationActionUserUnbanned, moderationTargetUser, userModel.ID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+	return nil
+}
+
+func fillUserBanResponse(ctx context.Context, tx *sqlx.Tx, banModel UserBanModel) (UserBan, error) {
+	userModel := UserModel{}
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ?", banModel.UserID); err != nil {
+		return UserBan{}, err
+	}
+	user, err := fillUserResponse(ctx, tx, userModel)
+	if err != nil {
+		return UserBan{}, err
+	}
+
+	ban := UserBan{
+		ID:           banModel.ID,
+		LivestreamID: banModel.LivestreamID,
+		User:         user,
+		Reason:       banModel.Reason,
+		CreatedBy:    banModel.CreatedBy,
+		CreatedAt:    banModel.CreatedAt,
+	}
+	if banModel.ExpiresAt.Valid {
+		ban.ExpiresAt = &banModel.ExpiresAt.Int64
+	}
+	return ban, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// ライブコメントを非表示にした理由
+const (
+	livecommentHiddenReasonNGWord = "ng_word"
+	// 通報がしきい値に達した
+	livecommentHiddenReasonReports = "reports"
+	// 配信者またはモデレーターが手動で非表示にした
+	livecommentHiddenReasonModerator = "moderator"
+)
+
+type HiddenLivecomment struct {
+	Livecomment  Livecomment `json:"livecomment"`
+	HiddenAt     int64       `json:"hidden_at"`
+	HiddenReason string      `json:"hidden_reason"`
+	// NGワードで非表示にされた場合のみ
+	NGWordID *int64 `json:"ng_word_id,omitempty"`
+	// 非表示にした配信者またはモデレーター。通報による自動非表示では省略
+	HiddenBy *int64 `json:"hidden_by,omitempty"`
+}
+
+// (配信者向け)非表示にされたライブコメント一覧取得API
+// GET /api/livestream/:livestream_id/livecomment/hidden
+func getHiddenLivecommentsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	if _, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID); err != nil {
+		return err
+	}
+
+	var livecommentModels []LivecommentModel
+	if err := tx.SelectContext(ctx, &livecommentModels, "SELECT * FROM livecomments WHERE livestream_id = ? AND hidden_at IS NOT NULL ORDER BY hidden_at DESC, id DESC", livestreamID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get hidden livecomments: "+err.Error())
+	}
+
+	hiddenLivecomments := make([]HiddenLivecomment, len(livecommentModels))
+	for i := range livecommentModels {
+		hiddenLivecomment, err := fillHiddenLivecommentResponse(ctx, tx, livecommentModels[i])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill hidden livecomment: "+err.Error())
+		}
+		hiddenLivecomments[i] = hiddenLivecomment
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, hiddenLivecomments)
+}
+
+// (配信者向け)非表示にされたライブコメントを元に戻すAPI
+// POST /api/livestream/:livestream_id/livecomment/:livecomment_id/restore
+func restoreLivecommentHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	livecommentID, err := strconv.Atoi(c.Param("livecomment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livecomment_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	var livecommentModel LivecommentModel
+	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ? AND livestream_id = ? FOR UPDATE", livecommentID, livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livecomment not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+		}
+	}
+	if !livecommentModel.HiddenAt.Valid {
+		return echo.NewHTTPError(http.StatusBadRequest, "livecomment is not hidden")
+	}
+
+	if err := restoreLivecomments(ctx, tx, []int64{livecommentModel.ID}, sql.NullInt64{Int64: userID, Valid: true}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore livecomment: "+err.Error())
+	}
+	// 確認待ちに積まれていれば、元に戻したことにする
+	if _, err := tx.ExecContext(ctx, "UPDATE livecomment_review_items SET status = ?, resolved_at = ?, resolved_by = ? WHERE livecomment_id = ? AND status = ?", reviewItemStatusRestored, time.Now().Unix(), userID, livecommentModel.ID, reviewItemStatusPending); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve review items: "+err.Error())
+	}
+	livecommentModel.HiddenAt = sql.NullInt64{}
+	livecommentModel.HiddenReason = sql.NullString{}
+	livecommentModel.HiddenNGWordID = sql.NullInt64{}
+	livecommentModel.HiddenBy = sql.NullInt64{}
+
+	livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	livestreamEvents.publish(livestreamModel.ID, livestreamEvent{
+		ID:   livecomment.ID,
+		Type: livestreamEventLivecommentRestored,
+		Data: livecomment,
+	})
+
+	return c.JSON(http.StatusOK, livecomment)
+}
+
+// (配信者・モデレーター向け)ライブコメントを手動で非表示にするAPI
+// POST /api/livestream/:livestream_id/livecomment/:livecomment_id/hide
+func hideLivecommentHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	livecommentID, err := strconv.Atoi(c.Param("livecomment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livecomment_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	var livecommentModel LivecommentModel
+	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ? AND livestream_id = ? FOR UPDATE", livecommentID, livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livecomment not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+		}
+	}
+	if livecommentModel.HiddenAt.Valid {
+		return echo.NewHTTPError(http.StatusBadRequest, "livecomment is already hidden")
+	}
+
+	if err := hideLivecomments(ctx, tx, []int64{livecommentModel.ID}, livecommentHiddenReasonModerator, sql.NullInt64{}, sql.NullInt64{Int64: userID, Valid: true}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide livecomment: "+err.Error())
+	}
+	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", livecommentModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+	}
+
+	hiddenLivecomment, err := fillHiddenLivecommentResponse(ctx, tx, livecommentModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill hidden livecomment: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	livestreamEvents.publish(livestreamModel.ID, livestreamEvent{
+		Type: livestreamEventLivecommentHidden,
+		Data: map[string]interface{}{
+			"livecomment_ids": []int64{livecommentModel.ID},
+			"reason":          livecommentHiddenReasonModerator,
+		},
+	})
+
+	return c.JSON(http.StatusOK, hiddenLivecomment)
+}
+
+// hideLivecomments はライブコメントを非表示にする。投げ銭の集計に残すため行は削除しない
+func hideLivecomments(ctx context.Context, tx *sqlx.Tx, livecommentIDs []int64, reason string, ngWordID sql.NullInt64, hiddenBy sql.NullInt64) error {
+	if len(livecommentIDs) == 0 {
+		return nil
+	}
+	now := time.Now().Unix()
+	// 実際に非表示になるものだけ記録するので、UPDATEより先に記録する
+	query, params, err := sqlx.In("INSERT INTO moderation_events (streamer_id, livestream_id, actor_id, action, target_type, target_id, detail, created_at) SELECT l.user_id, c.livestream_id, ?, ?, ?, c.id, JSON_OBJECT('reason', ?, 'ng_word_id', ?), ? FROM livecomments c INNER JOIN livestreams l ON l.id = c.livestream_id WHERE c.id IN (?) AND c.hidden_at IS NULL", hiddenBy, moderationActionLivecommentHidden, moderationTargetLivecomment, reason, ngWordID, now, livecommentIDs)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return err
+	}
+	query, params, err = sqlx.In("UPDATE livecomments SET hidden_at = ?, hidden_reason = ?, hidden_ng_word_id = ?, hidden_by = ? WHERE id IN (?) AND hidden_at IS NULL", now, reason, ngWordID, hiddenBy, livecommentIDs)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query, params...)
+	return err
+}
+
+// restoreLivecomments は非表示を解除する。restoredByが無効ならNGワードの変更などによる自動の解除として記録する
+func restoreLivecomments(ctx context.Context, tx *sqlx.Tx, livecommentIDs []int64, restoredBy sql.NullInt64) error {
+	if len(livecommentIDs) == 0 {
+		return nil
+	}
+	query, params, err := sqlx.In("INSERT INTO moderation_events (streamer_id, livestream_id, actor_id, action, target_type, target_id, detail, created_at) SELECT l.user_id, c.livestream_id, ?, ?, ?, c.id, JSON_OBJECT('hidden_reason', c.hidden_reason, 'ng_word_id', c.hidden_ng_word_id), ? FROM livecomments c INNER JOIN livestreams l ON l.id = c.livestream_id WHERE c.id IN (?) AND c.hidden_at IS NOT NULL", restoredBy, moderationActionLivecommentRestored, moderationTargetLivecomment, time.Now().Unix(), livecommentIDs)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return err
+	}
+	query, params, err = sqlx.In("UPDATE livecomments SET hidden_at = NULL, hidden_reason = NULL, hidden_ng_word_id = NULL, hidden_by = NULL WHERE id IN (?)", livecommentIDs)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query, params...)
+	return err
+}
+
+func fillHiddenLivecommentResponse(ctx context.Context, tx *sqlx.Tx, livecommentModel LivecommentModel) (HiddenLivecomment, error) {
+	livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
+	if err != nil {
+		return HiddenLivecomment{}, err
+	}
+
+	hiddenLivecomment := HiddenLivecomment{
+		Livecomment:  livecomment,
+		HiddenAt:     livecommentModel.HiddenAt.Int64,
+		HiddenReason: livecommentModel.HiddenReason.String,
+	}
+	if livecommentModel.HiddenNGWordID.Valid {
+		hiddenLivecomment.NGWordID = &livecommentModel.HiddenNGWordID.Int64
+	}
+	if livecommentModel.HiddenBy.Valid {
+		hiddenLivecomment.HiddenBy = &livecommentModel.HiddenBy.Int64
+	}
+	return hiddenLivecomment, nil
+}
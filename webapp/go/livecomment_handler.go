@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -27,6 +26,11 @@ type LivecommentModel struct {
 	Comment      string `db:"comment"`
 	Tip          int64  `db:"tip"`
 	CreatedAt    int64  `db:"created_at"`
+	// モデレーションで非表示にされたとき (削除はしない)
+	HiddenAt       sql.NullInt64  `db:"hidden_at"`
+	HiddenReason   sql.NullString `db:"hidden_reason"`
+	HiddenNGWordID sql.NullInt64  `db:"hidden_ng_word_id"`
+	HiddenBy       sql.NullInt64  `db:"hidden_by"`
 }
 
 type Livecomment struct {
@@ -43,18 +47,28 @@ type LivecommentReport struct {
 	Reporter    User        `json:"reporter"`
 	Livecomment Livecomment `json:"livecomment"`
 	CreatedAt   int64       `json:"created_at"`
+	Status      string      `json:"status"`
+	// 対応済みの場合のみ
+	ResolvedAt *int64 `json:"resolved_at,omitempty"`
+	ResolvedBy *int64 `json:"resolved_by,omitempty"`
 }
 
 type LivecommentReportModel struct {
-	ID            int64 `db:"id"`
-	UserID        int64 `db:"user_id"`
-	LivestreamID  int64 `db:"livestream_id"`
-	LivecommentID int64 `db:"livecomment_id"`
-	CreatedAt     int64 `db:"created_at"`
+	ID            int64         `db:"id"`
+	UserID        int64         `db:"user_id"`
+	LivestreamID  int64         `db:"livestream_id"`
+	LivecommentID int64         `db:"livecomment_id"`
+	Weight        float64       `db:"weight"`
+	CreatedAt     int64         `db:"created_at"`
+	Status        string        `db:"status"`
+	ResolvedAt    sql.NullInt64 `db:"resolved_at"`
+	ResolvedBy    sql.NullInt64 `db:"resolved_by"`
 }
 
 type ModerateRequest struct {
 	NGWord string `json:"ng_word"`
+	// 省略時はsubstring
+	MatchType string `json:"match_type"`
 }
 
 type NGWord struct {
@@ -62,7 +76,10 @@ type NGWord struct {
 	UserID       int64  `json:"user_id" db:"user_id"`
 	LivestreamID int64  `json:"livestream_id" db:"livestream_id"`
 	Word         string `json:"word" db:"word"`
-	CreatedAt    int64  `json:"created_at" db:"created_at"`
+	MatchType    string `json:"match_type" db:"match_type"`
+	// 登録した配信者またはモデレーター
+	CreatedBy *int64 `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt int64  `json:"created_at" db:"created_at"`
 }
 
 func getLivecommentsHandler(c echo.Context) error {
@@ -84,17 +101,15 @@ func getLivecommentsHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	query := "SELECT * FROM livecomments WHERE livestream_id = ? ORDER BY created_at DESC"
-	if c.QueryParam("limit") != "" {
-		limit, err := strconv.Atoi(c.QueryParam("limit"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
-		}
-		query += fmt.Sprintf(" LIMIT %d", limit)
+	paging, err := parsePageParams(c)
+	if err != nil {
+		return err
 	}
+	cond, order, args := paging.keysetQuery("created_at", "id", true)
+	query := "SELECT * FROM livecomments WHERE livestream_id = ? AND hidden_at IS NULL" + cond + order
 
 	livecommentModels := []LivecommentModel{}
-	err = tx.SelectContext(ctx, &livecommentModels, query, livestreamID)
+	err = tx.SelectContext(ctx, &livecommentModels, query, append([]interface{}{livestreamID}, args...)...)
 	if errors.Is(err, sql.ErrNoRows) {
 		return c.JSON(http.StatusOK, []*Livecomment{})
 	}
@@ -102,6 +117,13 @@ func getLivecommentsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomments: "+err.Error())
 	}
 
+	var page pageInfo
+	if paging.Paginated {
+		livecommentModels, page = paginate(paging, livecommentModels, func(m LivecommentModel) pageCursor {
+			return pageCursor{Key: m.CreatedAt, ID: m.ID}
+		})
+	}
+
 	livecomments := make([]Livecomment, len(livecommentModels))
 	for i := range livecommentModels {
 		livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModels[i])
@@ -116,6 +138,9 @@ func getLivecommentsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	if paging.Paginated {
+		return c.JSON(http.StatusOK, newPage(livecomments, page))
+	}
 	return c.JSON(http.StatusOK, livecomments)
 }
 
@@ -142,8 +167,36 @@ func getNgwords(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
+	// モデレーターには配信者が登録したNGワードを見せる
+	ownerID := userID
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err == nil {
+		if ok, err := canModerateLivestream(ctx, tx, livestreamModel, userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderator: "+err.Error())
+		} else if ok {
+			ownerID = livestreamModel.UserID
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+
+	query := "SELECT * FROM ng_words WHERE user_id = ? AND livestream_id = ? ORDER BY created_at DESC"
+	args := []interface{}{ownerID, livestreamID}
+	// この配信に適用される配信者の全配信向けNGワードも含める
+	if c.QueryParam("include_streamer_words") == "true" {
+		query = `
+		SELECT * FROM ng_words
+		WHERE user_id = ? AND (
+			livestream_id = ? OR
+			(livestream_id = ? AND id NOT IN (SELECT ng_word_id FROM ng_word_exclusions WHERE livestream_id = ?))
+		)
+		ORDER BY created_at DESC
+		`
+		args = []interface{}{ownerID, livestreamID, streamerNGWordLivestreamID, livestreamID}
+	}
+
 	var ngWords []*NGWord
-	if err := tx.SelectContext(ctx, &ngWords, "SELECT * FROM ng_words WHERE user_id = ? AND livestream_id = ? ORDER BY created_at DESC", userID, livestreamID); err != nil {
+	if err := tx.SelectContext(ctx, &ngWords, query, args...); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return c.JSON(http.StatusOK, []*NGWord{})
 		} else {
@@ -181,50 +234,70 @@ func postLivecommentHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
+	livecomment, err := insertLivecomment(ctx, userID, int64(livestreamID), req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, livecomment)
+}
+
+// insertLivecomment はスパム判定をしてライブコメントを保存し、購読者へ配信する
+// HTTPとWebSocketの両方から使うので、エラーはecho.NewHTTPErrorで返す (レート制限だけはtooManyRequestsError)
+func insertLivecomment(ctx context.Context, userID int64, livestreamID int64, req *PostLivecommentRequest) (Livecomment, error) {
+	rateLimit, err := postRateLimiter.reserve(userID, time.Now())
+	if err != nil {
+		return Livecomment{}, err
+	}
+	// BANやスパム判定などで投稿できなかったら、レート制限とスローモードに数えない
+	var slowMode *slowModeReservation
+	posted := false
+	defer func() {
+		if !posted {
+			rateLimit.Cancel()
+			slowMode.cancel()
+		}
+	}()
+
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+		return Livecomment{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
 	}
 	defer tx.Rollback()
 
 	var livestreamModel LivestreamModel
 	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+			return Livecomment{}, echo.NewHTTPError(http.StatusNotFound, "livestream not found")
 		} else {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+			return Livecomment{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
 		}
 	}
 
+	if err := verifyLivestreamLive(livestreamModel); err != nil {
+		return Livecomment{}, err
+	}
+	if err := verifyNotBanned(ctx, tx, livestreamID, userID); err != nil {
+		return Livecomment{}, err
+	}
+	slowMode, err = verifySlowMode(ctx, tx, livestreamModel, userID)
+	if err != nil {
+		return Livecomment{}, err
+	}
+
 	// スパム判定
-	var ngwords []*NGWord
-	if err := tx.SelectContext(ctx, &ngwords, "SELECT id, user_id, livestream_id, word FROM ng_words WHERE user_id = ? AND livestream_id = ?", livestreamModel.UserID, livestreamModel.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
-	}
-
-	var hitSpam int
-	for _, ngword := range ngwords {
-		query := `
-		SELECT COUNT(*)
-		FROM
-		(SELECT ? AS text) AS texts
-		INNER JOIN
-		(SELECT CONCAT('%', ?, '%')	AS pattern) AS patterns
-		ON texts.text LIKE patterns.pattern;
-		`
-		if err := tx.GetContext(ctx, &hitSpam, query, req.Comment, ngword.Word); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get hitspam: "+err.Error())
-		}
-		c.Logger().Infof("[hitSpam=%d] comment = %s", hitSpam, req.Comment)
-		if hitSpam >= 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, "このコメントがスパム判定されました")
-		}
+	matcher, err := ngWordMatchers.get(ctx, tx, livestreamModel)
+	if err != nil {
+		return Livecomment{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+	}
+	if matcher.match(req.Comment) != nil {
+		return Livecomment{}, echo.NewHTTPError(http.StatusBadRequest, "このコメントがスパム判定されました")
 	}
 
 	now := time.Now().Unix()
 	livecommentModel := LivecommentModel{
 		UserID:       userID,
-		LivestreamID: int64(livestreamID),
+		LivestreamID: livestreamID,
 		Comment:      req.Comment,
 		Tip:          req.Tip,
 		CreatedAt:    now,
@@ -232,25 +305,34 @@ func postLivecommentHandler(c echo.Context) error {
 
 	rs, err := tx.NamedExecContext(ctx, "INSERT INTO livecomments (user_id, livestream_id, comment, tip, created_at) VALUES (:user_id, :livestream_id, :comment, :tip, :created_at)", livecommentModel)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livecomment: "+err.Error())
+		return Livecomment{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livecomment: "+err.Error())
 	}
 
 	livecommentID, err := rs.LastInsertId()
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted livecomment id: "+err.Error())
+		return Livecomment{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted livecomment id: "+err.Error())
 	}
 	livecommentModel.ID = livecommentID
 
 	livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
+		return Livecomment{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
 	}
 
 	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		return Livecomment{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
+	posted = true
+	// スローモードの投稿時刻は保存できてから記録する
+	slowMode.commit(time.Now())
 
-	return c.JSON(http.StatusCreated, livecomment)
+	livestreamEvents.publish(livestreamID, livestreamEvent{
+		ID:   livecomment.ID,
+		Type: livestreamEventLivecomment,
+		Data: livecomment,
+	})
+
+	return livecomment, nil
 }
 
 func reportLivecommentHandler(c echo.Context) error {
@@ -290,8 +372,9 @@ func reportLivecommentHandler(c echo.Context) error {
 		}
 	}
 
+	// しきい値の判定が同時に走らないよう、ライブコメントをロックしておく。他の配信のライブコメントは通報できない
 	var livecommentModel LivecommentModel
-	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", livecommentID); err != nil {
+	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ? AND livestream_id = ? FOR UPDATE", livecommentID, livestreamModel.ID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return echo.NewHTTPError(http.StatusNotFound, "livecomment not found")
 		} else {
@@ -299,14 +382,37 @@ func reportLivecommentHandler(c echo.Context) error {
 		}
 	}
 
+	// 同じユーザからの同じライブコメントへの通報は1件にまとめる
+	// 2回目以降も新しく通報したときと同じく201で、最初の通報を返す (クライアントは重複を気にせず再送できる)
+	var reportModel LivecommentReportModel
+	if err := tx.GetContext(ctx, &reportModel, "SELECT * FROM livecomment_reports WHERE livecomment_id = ? AND user_id = ?", livecommentID, userID); err == nil {
+		report, err := fillLivecommentReportResponse(ctx, tx, reportModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment report: "+err.Error())
+		}
+		if err := tx.Commit(); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		}
+		return c.JSON(http.StatusCreated, report)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment report: "+err.Error())
+	}
+
+	weight, err := reportWeight(ctx, tx, userID, livestreamModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to calculate report weight: "+err.Error())
+	}
+
 	now := time.Now().Unix()
-	reportModel := LivecommentReportModel{
+	reportModel = LivecommentReportModel{
 		UserID:        int64(userID),
 		LivestreamID:  int64(livestreamID),
 		LivecommentID: int64(livecommentID),
+		Weight:        weight,
 		CreatedAt:     now,
+		Status:        livecommentReportStatusOpen,
 	}
-	rs, err := tx.NamedExecContext(ctx, "INSERT INTO livecomment_reports(user_id, livestream_id, livecomment_id, created_at) VALUES (:user_id, :livestream_id, :livecomment_id, :created_at)", &reportModel)
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO livecomment_reports(user_id, livestream_id, livecomment_id, weight, created_at, status) VALUES (:user_id, :livestream_id, :livecomment_id, :weight, :created_at, :status)", &reportModel)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livecomment report: "+err.Error())
 	}
@@ -316,6 +422,11 @@ func reportLivecommentHandler(c echo.Context) error {
 	}
 	reportModel.ID = reportID
 
+	hidden, err := hideLivecommentIfReportsExceedThreshold(ctx, tx, livecommentModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide reported livecomment: "+err.Error())
+	}
+
 	report, err := fillLivecommentReportResponse(ctx, tx, reportModel)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment report: "+err.Error())
@@ -324,6 +435,16 @@ func reportLivecommentHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	if hidden {
+		livestreamEvents.publish(livecommentModel.LivestreamID, livestreamEvent{
+			Type: livestreamEventLivecommentHidden,
+			Data: map[string]interface{}{
+				"livecomment_ids": []int64{livecommentModel.ID},
+				"reason":          livecommentHiddenReasonReports,
+			},
+		})
+	}
+
 	return c.JSON(http.StatusCreated, report)
 }
 
@@ -350,6 +471,12 @@ func moderateHandler(c echo.Context) error {
 	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
+	if req.MatchType == "" {
+		req.MatchType = ngWordMatchTypeSubstring
+	}
+	if !isValidNGWordMatchType(req.MatchType) {
+		return echo.NewHTTPError(http.StatusBadRequest, "match_type must be one of substring, whole_word, regex, glob")
+	}
 
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
@@ -357,21 +484,39 @@ func moderateHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	// 配信者自身の配信に対するmoderateなのかを検証
-	var ownedLivestreams []LivestreamModel
-	if err := tx.SelectContext(ctx, &ownedLivestreams, "SELECT * FROM livestreams WHERE id = ? AND user_id = ?", livestreamID, userID); err != nil {
+	// 配信者自身またはモデレーターを任された配信に対するmoderateなのかを検証
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
 	}
-	if len(ownedLivestreams) == 0 {
+	if livestreamModel.ID == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "A streamer can't moderate livestreams that other streamers own")
+	}
+	if ok, err := canModerateLivestream(ctx, tx, livestreamModel, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderator: "+err.Error())
+	} else if !ok {
 		return echo.NewHTTPError(http.StatusBadRequest, "A streamer can't moderate livestreams that other streamers own")
 	}
 
-	rs, err := tx.NamedExecContext(ctx, "INSERT INTO ng_words(user_id, livestream_id, word, created_at) VALUES (:user_id, :livestream_id, :word, :created_at)", &NGWord{
-		UserID:       int64(userID),
+	settingsModel, err := getLivestreamSettings(ctx, tx, int64(livestreamID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream settings: "+err.Error())
+	}
+
+	// モデレーターが登録しても、NGワードは配信者のものとして扱う
+	ngword := &NGWord{
+		UserID:       livestreamModel.UserID,
 		LivestreamID: int64(livestreamID),
 		Word:         req.NGWord,
+		MatchType:    req.MatchType,
+		CreatedBy:    &userID,
 		CreatedAt:    time.Now().Unix(),
-	})
+	}
+	if _, err := compileNGWordRule(ngword, settingsModel.NGWordStrictness); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid NG word: "+err.Error())
+	}
+
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO ng_words(user_id, livestream_id, word, match_type, created_by, created_at) VALUES (:user_id, :livestream_id, :word, :match_type, :created_by, :created_at)", ngword)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert new NG word: "+err.Error())
 	}
@@ -380,48 +525,28 @@ func moderateHandler(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted NG word id: "+err.Error())
 	}
+	ngword.ID = wordID
+	if err := recordModerationEvent(ctx, tx, livestreamModel.UserID, livestreamModel.ID, userID, moderationActionNGWordAdded, moderationTargetNGWord, ngword.ID, map[string]interface{}{
+		"word":       ngword.Word,
+		"match_type": ngword.MatchType,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
 
-	var ngwords []*NGWord
-	if err := tx.SelectContext(ctx, &ngwords, "SELECT * FROM ng_words WHERE livestream_id = ?", livestreamID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
-	}
-
-	// あまり、スコア上がってない。なんなら下がってる。時間でいうと中央値5sくらいのが1sかからないくらいくらいになっているので、残しておく
-	if _, err := tx.ExecContext(ctx, `DELETE FROM livecomments WHERE livestream_id = ? AND comment LIKE CONCAT('%', ?, '%')`, livestreamID, req.NGWord); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old livecomments that hit spams: "+err.Error())
-	}
-
-	// NGワードにヒットする過去の投稿も全削除する
-	// for _, ngword := range ngwords {
-	// 	// ライブコメント一覧取得
-	// 	var livecomments []*LivecommentModel
-	// 	if err := tx.SelectContext(ctx, &livecomments, "SELECT * FROM livecomments"); err != nil {
-	// 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomments: "+err.Error())
-	// 	}
-
-	// 	for _, livecomment := range livecomments {
-	// 		query := `
-	// 		DELETE FROM livecomments
-	// 		WHERE
-	// 		id = ? AND
-	// 		livestream_id = ? AND
-	// 		(SELECT COUNT(*)
-	// 		FROM
-	// 		(SELECT ? AS text) AS texts
-	// 		INNER JOIN
-	// 		(SELECT CONCAT('%', ?, '%')	AS pattern) AS patterns
-	// 		ON texts.text LIKE patterns.pattern) >= 1;
-	// 		`
-	// 		if _, err := tx.ExecContext(ctx, query, livecomment.ID, livestreamID, livecomment.Comment, ngword.Word); err != nil {
-	// 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old livecomments that hit spams: "+err.Error())
-	// 		}
-	// 	}
-	// }
+	// 削除すると投げ銭の集計が変わってしまうので、非表示にするだけにする
+	hiddenLivecommentIDs, err := hideLivecommentsMatchingNGWord(ctx, tx, int64(livestreamID), ngword, settingsModel.NGWordStrictness, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide old livecomments that hit spams: "+err.Error())
+	}
 
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	ngWordMatchers.addWord(int64(livestreamID), ngword)
+
+	publishNGWordModeration(int64(livestreamID), wordID, hiddenLivecommentIDs, nil)
+
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"word_id": wordID,
 	})
@@ -459,20 +584,26 @@ func fillLivecommentResponse(ctx context.Context, tx *sqlx.Tx, livecommentModel
 }
 
 func fillLivecommentReportResponse(ctx context.Context, tx *sqlx.Tx, reportModel LivecommentReportModel) (LivecommentReport, error) {
-	reporterModel := UserModel{}
-	if err := tx.GetContext(ctx, &reporterModel, "SELECT * FROM users WHERE id = ?", reportModel.UserID); err != nil {
+	livecommentModel := LivecommentModel{}
+	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", reportModel.LivecommentID); err != nil {
 		return LivecommentReport{}, err
 	}
-	reporter, err := fillUserResponse(ctx, tx, reporterModel)
+	livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
 	if err != nil {
 		return LivecommentReport{}, err
 	}
 
-	livecommentModel := LivecommentModel{}
-	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", reportModel.LivecommentID); err != nil {
+	return fillLivecommentReportResponseWithLivecomment(ctx, tx, reportModel, livecomment)
+}
+
+// fillLivecommentReportResponseWithLivecomment は取得済みのライブコメントを使って通報を組み立てる
+// 同じライブコメントへの通報をまとめて返すときに、ライブコメントを何度も組み立てないようにするため
+func fillLivecommentReportResponseWithLivecomment(ctx context.Context, tx *sqlx.Tx, reportModel LivecommentReportModel, livecomment Livecomment) (LivecommentReport, error) {
+	reporterModel := UserModel{}
+	if err := tx.GetContext(ctx, &reporterModel, "SELECT * FROM users WHERE id = ?", reportModel.UserID); err != nil {
 		return LivecommentReport{}, err
 	}
-	livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
+	reporter, err := fillUserResponse(ctx, tx, reporterModel)
 	if err != nil {
 		return LivecommentReport{}, err
 	}
@@ -482,6 +613,13 @@ func fillLivecommentReportResponse(ctx context.Context, tx *sqlx.Tx, reportModel
 		Reporter:    reporter,
 		Livecomment: livecomment,
 		CreatedAt:   reportModel.CreatedAt,
+		Status:      reportModel.Status,
+	}
+	if reportModel.ResolvedAt.Valid {
+		report.ResolvedAt = &reportModel.ResolvedAt.Int64
+	}
+	if reportModel.ResolvedBy.Valid {
+		report.ResolvedBy = &reportModel.ResolvedBy.Int64
 	}
 	return report, nil
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// 通報の対応状況
+const (
+	livecommentReportStatusOpen = "open"
+	// 問題なしとして却下した。通報による自動非表示のしきい値にも数えない
+	livecommentReportStatusDismissed = "dismissed"
+	// ライブコメントを非表示にした
+	livecommentReportStatusActioned = "actioned"
+)
+
+func isValidLivecommentReportStatus(status string) bool {
+	switch status {
+	case livecommentReportStatusOpen, livecommentReportStatusDismissed, livecommentReportStatusActioned:
+		return true
+	}
+	return false
+}
+
+// ライブコメントごとにまとめた通報
+type LivecommentReportGroup struct {
+	Livecomment   Livecomment `json:"livecomment"`
+	ReporterCount int64       `json:"reporter_count"`
+	// 通報の重みの合計
+	Score           float64 `json:"score"`
+	ReportIDs       []int64 `json:"report_ids"`
+	FirstReportedAt int64   `json:"first_reported_at"`
+	LastReportedAt  int64   `json:"last_reported_at"`
+}
+
+type ResolveLivecommentReportsRequest struct {
+	// dismissed または actioned
+	Status string `json:"status"`
+}
+
+// (配信者・モデレーター向け)ライブコメントへの未対応の通報をまとめて対応済みにするAPI
+// POST /api/livestream/:livestream_id/livecomment/:livecomment_id/report/resolve
+// actionedならライブコメントを非表示にする
+func resolveLivecommentReportsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	livecommentID, err := strconv.Atoi(c.Param("livecomment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livecomment_id in path must be integer")
+	}
+
+	var req *ResolveLivecommentReportsRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.Status != livecommentReportStatusDismissed && req.Status != livecommentReportStatusActioned {
+		return echo.NewHTTPError(http.StatusBadRequest, "status must be one of dismissed, actioned")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	var livecommentModel LivecommentModel
+	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ? AND livestream_id = ? FOR UPDATE", livecommentID, livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livecomment not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+		}
+	}
+
+	var reportModels []LivecommentReportModel
+	if err := tx.SelectContext(ctx, &reportModels, "SELECT * FROM livecomment_reports WHERE livecomment_id = ? AND status = ? ORDER BY created_at ASC, id ASC FOR UPDATE", livecommentModel.ID, livecommentReportStatusOpen); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment reports: "+err.Error())
+	}
+	if len(reportModels) == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "livecomment has no open reports")
+	}
+
+	now := time.Now().Unix()
+	reportIDs := make([]int64, len(reportModels))
+	for i := range reportModels {
+		reportIDs[i] = reportModels[i].ID
+		reportModels[i].Status = req.Status
+		reportModels[i].ResolvedAt = sql.NullInt64{Int64: now, Valid: true}
+		reportModels[i].ResolvedBy = sql.NullInt64{Int64: userID, Valid: true}
+	}
+	query, params, err := sqlx.In("UPDATE livecomment_reports SET status = ?, resolved_at = ?, resolved_by = ? WHERE id IN (?)", req.Status, now, userID, reportIDs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to construct IN query: "+err.Error())
+	}
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livecomment reports: "+err.Error())
+	}
+	if err := recordModerationEvent(ctx, tx, livestreamModel.UserID, livestreamModel.ID, userID, moderationActionReportResolved, moderationTargetLivecomment, livecommentModel.ID, map[string]interface{}{
+		"status":     req.Status,
+		"report_ids": reportIDs,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+
+	hidden := false
+	var restoredLivecomment *Livecomment
+	if req.Status == livecommentReportStatusActioned {
+		if !livecommentModel.HiddenAt.Valid {
+			if err := hideLivecomments(ctx, tx, []int64{livecommentModel.ID}, livecommentHiddenReasonModerator, sql.NullInt64{}, sql.NullInt64{Int64: userID, Valid: true}); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide livecomment: "+err.Error())
+			}
+			hidden = true
+		}
+		// 通報で自動的に非表示にして確認待ちになっていれば、非表示のままにしたことにする
+		if _, err := tx.ExecContext(ctx, "UPDATE livecomment_review_items SET status = ?, resolved_at = ?, resolved_by = ? WHERE livecomment_id = ? AND status = ?", reviewItemStatusKept, now, userID, livecommentModel.ID, reviewItemStatusPending); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve review items: "+err.Error())
+		}
+		if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", livecommentModel.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+		}
+	} else {
+		// 通報で自動的に非表示にしていれば元に戻し、確認待ちも元に戻したことにする
+		// 他の理由で非表示になっていれば、非表示のままにしたことにする
+		reviewStatus := reviewItemStatusKept
+		restored := false
+		if livecommentModel.HiddenAt.Valid && livecommentModel.HiddenReason.Valid && livecommentModel.HiddenReason.String == livecommentHiddenReasonReports {
+			if err := restoreLivecomments(ctx, tx, []int64{livecommentModel.ID}, sql.NullInt64{Int64: userID, Valid: true}); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore livecomment: "+err.Error())
+			}
+			reviewStatus = reviewItemStatusRestored
+			restored = true
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE livecomment_review_items SET status = ?, resolved_at = ?, resolved_by = ? WHERE livecomment_id = ? AND status = ?", reviewStatus, now, userID, livecommentModel.ID, reviewItemStatusPending); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve review items: "+err.Error())
+		}
+		if restored {
+			if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", livecommentModel.ID); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+			}
+			livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
+			}
+			restoredLivecomment = &livecomment
+		}
+	}
+
+	groups, err := groupLivecommentReports(ctx, tx, reportModels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment reports: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	if hidden {
+		livestreamEvents.publish(livestreamModel.ID, livestreamEvent{
+			Type: livestreamEventLivecommentHidden,
+			Data: map[string]interface{}{
+				"livecomment_ids": []int64{livecommentModel.ID},
+				"reason":          livecommentHiddenReasonModerator,
+			},
+		})
+	}
+
+	if restoredLivecomment != nil {
+		livestreamEvents.publish(livestreamModel.ID, livestreamEvent{
+			ID:   restoredLivecomment.ID,
+			Type: livestreamEventLivecommentRestored,
+			Data: restoredLivecomment,
+		})
+	}
+
+	return c.JSON(http.StatusOK, groups[0])
+}
+
+// resolveOpenLivecommentReports はライブコメントへの未対応の通報をまとめてstatusにし、対応した通報のidを返す
+func resolveOpenLivecommentReports(ctx context.Context, tx *sqlx.Tx, livecommentID int64, status string, resolvedBy int64, now int64) ([]int64, error) {
+	var reportIDs []int64
+	if err := tx.SelectContext(ctx, &reportIDs, "SELECT id FROM livecomment_reports WHERE livecomment_id = ? AND status = ? ORDER BY id FOR UPDATE", livecommentID, livecommentReportStatusOpen); err != nil {
+		return nil, err
+	}
+	if len(reportIDs) == 0 {
+		return reportIDs, nil
+	}
+	query, params, err := sqlx.In("UPDATE livecomment_reports SET status = ?, resolved_at = ?, resolved_by = ? WHERE id IN (?)", status, now, resolvedBy, reportIDs)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, tx.Rebind(query), params...); err != nil {
+		return nil, err
+	}
+	return reportIDs, nil
+}
+
+// fillLivecommentReportResponses は通報一覧を組み立てる。同じライブコメントは一度だけ組み立てる
+func fillLivecommentReportResponses(ctx context.Context, tx *sqlx.Tx, reportModels []*LivecommentReportModel) ([]LivecommentReport, error) {
+	livecomments := map[int64]Livecomment{}
+	reports := make([]LivecommentReport, len(reportModels))
+	for i, reportModel := range reportModels {
+		livecomment, ok := livecomments[reportModel.LivecommentID]
+		if !ok {
+			livecommentModel := LivecommentModel{}
+			if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", reportModel.LivecommentID); err != nil {
+				return nil, err
+			}
+			var err error
+			livecomment, err = fillLivecommentResponse(ctx, tx, livecommentModel)
+			if err != nil {
+				return nil, err
+			}
+			livecomments[reportModel.LivecommentID] = livecomment
+		}
+
+		report, err := fillLivecommentReportResponseWithLivecomment(ctx, tx, *reportModel, livecomment)
+		if err != nil {
+			return nil, err
+		}
+		reports[i] = report
+	}
+	return reports, nil
+}
+
+// groupLivecommentReports は通報をライブコメントごとにまとめる
+// 通報したユーザが多い順、同じなら最初に通報された順に並べる
+func groupLivecommentReports(ctx context.Context, tx *sqlx.Tx, reportModels []LivecommentReportModel) ([]LivecommentReportGroup, error) {
+	var livecommentIDs []int64
+	groupModels := map[int64][]LivecommentReportModel{}
+	for _, reportModel := range reportModels {
+		if _, ok := groupModels[reportModel.LivecommentID]; !ok {
+			livecommentIDs = append(livecommentIDs, reportModel.LivecommentID)
+		}
+		groupModels[reportModel.LivecommentID] = append(groupModels[reportModel.LivecommentID], reportModel)
+	}
+
+	groups := make([]LivecommentReportGroup, len(livecommentIDs))
+	for i, livecommentID := range livecommentIDs {
+		livecommentModel := LivecommentModel{}
+		if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", livecommentID); err != nil {
+			return nil, err
+		}
+		livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
+		if err != nil {
+			return nil, err
+		}
+
+		group := LivecommentReportGroup{
+			Livecomment: livecomment,
+			ReportIDs:   []int64{},
+		}
+		reporters := map[int64]struct{}{}
+		for _, reportModel := range groupModels[livecommentID] {
+			reporters[reportModel.UserID] = struct{}{}
+			group.Score += reportModel.Weight
+			group.ReportIDs = append(group.ReportIDs, reportModel.ID)
+			if group.FirstReportedAt == 0 || reportModel.CreatedAt < group.FirstReportedAt {
+				group.FirstReportedAt = reportModel.CreatedAt
+			}
+			if reportModel.CreatedAt > group.LastReportedAt {
+				group.LastReportedAt = reportModel.CreatedAt
+			}
+		}
+		group.ReporterCount = int64(len(reporters))
+		groups[i] = group
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].ReporterCount != groups[j].ReporterCount {
+			return groups[i].ReporterCount > groups[j].ReporterCount
+		}
+		return groups[i].FirstReportedAt < groups[j].FirstReportedAt
+	})
+	return groups, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// 通報による自動非表示のしきい値の判定方法
+const (
+	// 自動では非表示にしない (従来の挙動)
+	reportThresholdModeNone = "none"
+	// 通報したユーザ数
+	reportThresholdModeCount = "count"
+	// 通報の重みの合計
+	reportThresholdModeScore = "score"
+)
+
+// 確認待ちの状態
+const (
+	reviewItemStatusPending = "pending"
+	// 配信者が非表示のままにした
+	reviewItemStatusKept = "kept"
+	// 配信者が元に戻した
+	reviewItemStatusRestored = "restored"
+)
+
+func isValidReviewItemStatus(status string) bool {
+	switch status {
+	case reviewItemStatusPending, reviewItemStatusKept, reviewItemStatusRestored:
+		return true
+	}
+	return false
+}
+
+func isValidReportThresholdMode(mode string) bool {
+	switch mode {
+	case reportThresholdModeNone, reportThresholdModeCount, reportThresholdModeScore:
+		return true
+	}
+	return false
+}
+
+type LivecommentReviewItemModel struct {
+	ID            int64         `db:"id"`
+	LivestreamID  int64         `db:"livestream_id"`
+	LivecommentID int64         `db:"livecomment_id"`
+	Reason        string        `db:"reason"`
+	Score         float64       `db:"score"`
+	Status        string        `db:"status"`
+	CreatedAt     int64         `db:"created_at"`
+	ResolvedAt    sql.NullInt64 `db:"resolved_at"`
+	ResolvedBy    sql.NullInt64 `db:"resolved_by"`
+}
+
+type LivecommentReviewItem struct {
+	ID           int64       `json:"id"`
+	LivestreamID int64       `json:"livestream_id"`
+	Livecomment  Livecomment `json:"livecomment"`
+	Reason       string      `json:"reason"`
+	Score        float64     `json:"score"`
+	Status       string      `json:"status"`
+	CreatedAt    int64       `json:"created_at"`
+	ResolvedAt   *int64      `json:"resolved_at,omitempty"`
+	// 確認した配信者またはモデレーター
+	ResolvedBy *int64 `json:"resolved_by,omitempty"`
+}
+
+type ResolveReviewItemRequest struct {
+	// kept (非表示のまま) または restored (元に戻す)
+	Status string `json:"status"`
+}
+
+// (配信者向け)自動で非表示にしたライブコメントの確認待ち一覧取得API
+// GET /api/livestream/:livestream_id/review
+func getLivecommentReviewItemsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	status := reviewItemStatusPending
+	if v := c.QueryParam("status"); v != "" {
+		if !isValidReviewItemStatus(v) {
+			return echo.NewHTTPError(http.StatusBadRequest, "status must be one of pending, kept, restored")
+		}
+		status = v
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	if _, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID); err != nil {
+		return err
+	}
+
+	var itemModels []LivecommentReviewItemModel
+	if err := tx.SelectContext(ctx, &itemModels, "SELECT * FROM livecomment_review_items WHERE livestream_id = ? AND status = ? ORDER BY created_at DESC, id DESC", livestreamID, status); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get review items: "+err.Error())
+	}
+
+	items := make([]LivecommentReviewItem, len(itemModels))
+	for i := range itemModels {
+		item, err := fillLivecommentReviewItemResponse(ctx, tx, itemModels[i])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill review item: "+err.Error())
+		}
+		items[i] = item
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, items)
+}
+
+// (配信者向け)確認待ちのライブコメントを、非表示のままにするか元に戻すかを決めるAPI
+// POST /api/livestream/:livestream_id/review/:review_item_id/resolve
+func resolveLivecommentReviewItemHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	reviewItemID, err := strconv.Atoi(c.Param("review_item_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "review_item_id in path must be integer")
+	}
+
+	var req *ResolveReviewItemRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.Status != reviewItemStatusKept && req.Status != reviewItemStatusRestored {
+		return echo.NewHTTPError(http.StatusBadRequest, "status must be one of kept, restored")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	var itemModel LivecommentReviewItemModel
+	if err := tx.GetContext(ctx, &itemModel, "SELECT * FROM livecomment_review_items WHERE id = ? AND livestream_id = ? FOR UPDATE", reviewItemID, livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "review item not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get review item: "+err.Error())
+		}
+	}
+	if itemModel.Status != reviewItemStatusPending {
+		return echo.NewHTTPError(http.StatusConflict, "review item is already resolved")
+	}
+
+	now := time.Now().Unix()
+	if _, err := tx.ExecContext(ctx, "UPDATE livecomment_review_items SET status = ?, resolved_at = ?, resolved_by = ? WHERE id = ?", req.Status, now, userID, itemModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update review item: "+err.Error())
+	}
+	// 非表示のままにしたなら通報は対応済み、元に戻したなら却下として、通報の一覧とそろえる
+	reportStatus := livecommentReportStatusActioned
+	if req.Status == reviewItemStatusRestored {
+		reportStatus = livecommentReportStatusDismissed
+	}
+	reportIDs, err := resolveOpenLivecommentReports(ctx, tx, itemModel.LivecommentID, reportStatus, userID, now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve livecomment reports: "+err.Error())
+	}
+	if err := recordModerationEvent(ctx, tx, livestreamModel.UserID, livestreamModel.ID, userID, moderationActionReportResolved, moderationTargetReviewItem, itemModel.ID, map[string]interface{}{
+		"livecomment_id": itemModel.LivecommentID,
+		"status":         req.Status,
+		"report_ids":     reportIDs,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+	itemModel.Status = req.Status
+	itemModel.ResolvedAt = sql.NullInt64{Int64: now, Valid: true}
+	itemModel.ResolvedBy = sql.NullInt64{Int64: userID, Valid: true}
+
+	if req.Status == reviewItemStatusRestored {
+		if err := restoreLivecomments(ctx, tx, []int64{itemModel.LivecommentID}, sql.NullInt64{Int64: userID, Valid: true}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore livecomment: "+err.Error())
+		}
+	}
+
+	item, err := fillLivecommentReviewItemResponse(ctx, tx, itemModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill review item: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	if req.Status == reviewItemStatusRestored {
+		livestreamEvents.publish(livestreamModel.ID, livestreamEvent{
+			ID:   item.Livecomment.ID,
+			Type: livestreamEventLivecommentRestored,
+			Data: item.Livecomment,
+		})
+	}
+
+	return c.JSON(http.StatusOK, item)
+}
+
+// reportWeight は通報者の信頼度による通報の重みを返す
+// 投げ銭をしている視聴者の通報は重く、自分のコメントを非表示にされたことがあるユーザの通報は軽く扱う
+func reportWeight(ctx context.Context, tx *sqlx.Tx, reporterID int64, livestreamID int64) (float64, error) {
+	weight := 1.0
+
+	var tipCount int64
+	if err := tx.GetContext(ctx, &tipCount, "SELECT COUNT(*) FROM livecomments WHERE user_id = ? AND livestream_id = ? AND tip > 0", reporterID, livestreamID); err != nil {
+		return 0, err
+	}
+	if tipCount > 0 {
+		weight += 0.5
+	}
+
+	var hiddenCount int64
+	if err := tx.GetContext(ctx, &hiddenCount, "SELECT COUNT(*) FROM livecomments WHERE user_id = ? AND hidden_at IS NOT NULL", reporterID); err != nil {
+		return 0, err
+	}
+	if hiddenCount > 0 {
+		weight /= 2
+	}
+
+	return weight, nil
+}
+
+// hideLivecommentIfReportsExceedThreshold は通報がしきい値に達していればライブコメントを非表示にし、確認待ちに積む
+// 配信者が一度元に戻したライブコメントは、通報が増えても自動では非表示にしない。却下した通報は数えない
+func hideLivecommentIfReportsExceedThreshold(ctx context.Context, tx *sqlx.Tx, livecommentModel LivecommentModel) (bool, error) {
+	if livecommentModel.HiddenAt.Valid {
+		return false, nil
+	}
+
+	settingsModel, err := getLivestreamSettings(ctx, tx, livecommentModel.LivestreamID)
+	if err != nil {
+		return false, err
+	}
+
+	var score float64
+	switch settingsModel.ReportThresholdMode {
+	case reportThresholdModeCount:
+		if err := tx.GetContext(ctx, &score, "SELECT COUNT(DISTINCT user_id) FROM livecomment_reports WHERE livecomment_id = ? AND status != ?", livecommentModel.ID, livecommentReportStatusDismissed); err != nil {
+			return false, err
+		}
+	case reportThresholdModeScore:
+		if err := tx.GetContext(ctx, &score, "SELECT IFNULL(SUM(weight), 0) FROM livecomment_reports WHERE livecomment_id = ? AND status != ?", livecommentModel.ID, livecommentReportStatusDismissed); err != nil {
+			return false, err
+		}
+	default:
+		return false, nil
+	}
+	if score < settingsModel.ReportThreshold {
+		return false, nil
+	}
+
+	var restoredCount int64
+	if err := tx.GetContext(ctx, &restoredCount, "SELECT COUNT(*) FROM livecomment_review_items WHERE livecomment_id = ? AND status = ?", livecommentModel.ID, reviewItemStatusRestored); err != nil {
+		return false, err
+	}
+	if restoredCount > 0 {
+		return false, nil
+	}
+
+	if err := hideLivecomments(ctx, tx, []int64{livecommentModel.ID}, livecommentHiddenReasonReports, sql.NullInt64{}, sql.NullInt64{}); err != nil {
+		return false, err
+	}
+
+	itemModel := LivecommentReviewItemModel{
+		LivestreamID:  livecommentModel.LivestreamID,
+		LivecommentID: livecommentModel.ID,
+		Reason:        livecommentHiddenReasonReports,
+		Score:         score,
+		Status:        reviewItemStatusPending,
+		CreatedAt:     time.Now().Unix(),
+	}
+	if _, err := tx.NamedExecContext(ctx, "INSERT INTO livecomment_review_items (livestream_id, livecomment_id, reason, score, status, created_at) VALUES (:livestream_id, :livecomment_id, :reason, :score, :status, :created_at)", itemModel); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func fillLivecommentReviewItemResponse(ctx context.Context, tx *sqlx.Tx, itemModel LivecommentReviewItemModel) (LivecommentReviewItem, error) {
+	var livecommentModel LivecommentModel
+	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", itemModel.LivecommentID); err != nil {
+		return LivecommentReviewItem{}, err
+	}
+	livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
+	if err != nil {
+		return LivecommentReviewItem{}, err
+	}
+
+	item := LivecommentReviewItem{
+		ID:           itemModel.ID,
+		LivestreamID: itemModel.LivestreamID,
+		Livecomment:  livecomment,
+		Reason:       itemModel.Reason,
+		Score:        itemModel.Score,
+		Status:       itemModel.Status,
+		CreatedAt:    itemModel.CreatedAt,
+	}
+	if itemModel.ResolvedAt.Valid {
+		item.ResolvedAt = &itemModel.ResolvedAt.Int64
+	}
+	if itemModel.ResolvedBy.Valid {
+		item.ResolvedBy = &itemModel.ResolvedBy.Int64
+	}
+	return item, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	livecommentStreamHeartbeatInterval = 15 * time.Second
+	// Last-Event-IDからの再送で一度に読み込む件数。追いつくまで繰り返し読む
+	livecommentStreamResumeBatchSize = 1000
+	// 送信済みとして覚えておくライブコメントの件数
+	// 通知はコミット後に送るので、idの順に届くとは限らない。覚えている分は順序が前後しても重複・取りこぼしを防げる
+	livecommentStreamSentIDsSize = 1024
+)
+
+// ライブコメントのSSE配信API
+// GET /api/livestream/:livestream_id/livecomment/stream
+func streamLivecommentsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var lastEventID int64
+	if v := c.Request().Header.Get("Last-Event-ID"); v != "" {
+		lastEventID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Last-Event-ID header must be integer")
+		}
+	}
+
+	// 取りこぼしが出ないよう、再送分を読む前に購読を始めておく
+	sub := livestreamEvents.subscribe(int64(livestreamID))
+	defer livestreamEvents.unsubscribe(int64(livestreamID), sub)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	// nginxでバッファリングされないようにする
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	sent := newSentLivecommentIDs(livecommentStreamSentIDsSize)
+	// Last-Event-ID以降に投稿されたライブコメントを、追いつくまで区切って再送する
+	resumedFrom := lastEventID
+	if lastEventID > 0 {
+		for {
+			livecomments, err := getLivecommentsForResume(ctx, int64(livestreamID), lastEventID)
+			if err != nil {
+				c.Logger().Errorf("failed to get livecomments: %v", err)
+				return nil
+			}
+			for _, livecomment := range livecomments {
+				if err := writeServerSentEvent(res, livestreamEvent{ID: livecomment.ID, Type: livestreamEventLivecomment, Data: livecomment}); err != nil {
+					return nil
+				}
+				sent.add(livecomment.ID)
+				lastEventID = livecomment.ID
+			}
+			if len(livecomments) < livecommentStreamResumeBatchSize {
+				break
+			}
+			res.Flush()
+		}
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(livecommentStreamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sub.dropped:
+			// 受信が遅れて切り離された。クライアントはLast-Event-IDで再接続してくる
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprintf(res, "event: heartbeat\ndata: %d\n\n", time.Now().Unix()); err != nil {
+				return nil
+			}
+			res.Flush()
+		case event := <-sub.events:
+			if event.Type != livestreamEventLivecomment {
+				continue
+			}
+			// 再送分や送信済みのものは送らない。idが前後して届いたものは、まだ送っていなければ送る
+			if event.ID <= resumedFrom || sent.contains(event.ID) {
+				continue
+			}
+			if err := writeServerSentEvent(res, event); err != nil {
+				return nil
+			}
+			sent.add(event.ID)
+			res.Flush()
+		}
+	}
+}
+
+// getLivecommentsForResume はafterIDより後に投稿されたライブコメントを、古い順に最大livecommentStreamResumeBatchSize件返す
+func getLivecommentsForResume(ctx context.Context, livestreamID int64, afterID int64) ([]Livecomment, error) {
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var livecommentModels []LivecommentModel
+	if err := tx.SelectContext(ctx, &livecommentModels, "SELECT * FROM livecomments WHERE livestream_id = ? AND id > ? AND hidden_at IS NULL ORDER BY id ASC LIMIT ?", livestreamID, afterID, livecommentStreamResumeBatchSize); err != nil {
+		return nil, err
+	}
+	livecomments := make([]Livecomment, 0, len(livecommentModels))
+	for i := range livecommentModels {
+		livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModels[i])
+		if err != nil {
+			return nil, err
+		}
+		livecomments = append(livecomments, livecomment)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return livecomments, nil
+}
+
+// sentLivecommentIDs は直近に送信したライブコメントのidを、古いものから忘れながら覚えておく
+type sentLivecommentIDs struct {
+	ids   map[int64]struct{}
+	order []int64
+	next  int
+}
+
+func newSentLivecommentIDs(size int) *sentLivecommentIDs {
+	return &sentLivecommentIDs{
+		ids:   make(map[int64]struct{}, size),
+		order: make([]int64, 0, size),
+	}
+}
+
+func (s *sentLivecommentIDs) contains(id int64) bool {
+	_, ok := s.ids[id]
+	return ok
+}
+
+func (s *sentLivecommentIDs) add(id int64) {
+	if s.contains(id) {
+		return
+	}
+	if len(s.order) < cap(s.order) {
+		s.order = append(s.order, id)
+	} else {
+		delete(s.ids, s.order[s.next])
+		s.order[s.next] = id
+		s.next = (s.next + 1) % len(s.order)
+	}
+	s.ids[id] = struct{}{}
+}
+
+func writeServerSentEvent(res *echo.Response, event livestreamEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// コラボレーターの招待の状態
+const (
+	collaboratorStatusInvited  = "invited"
+	collaboratorStatusAccepted = "accepted"
+	collaboratorStatusDeclined = "declined"
+)
+
+// 1つの配信に招待できるコラボレーターの上限
+const maxLivestreamCollaborators = 10
+
+// userLivestreamsQuery はユーザの配信と、コラボレーターとして参加している配信の (user_id, livestream_id) を返す
+// 統計情報でユーザに紐づく配信として扱う
+var userLivestreamsQuery = fmt.Sprintf(`(
+	SELECT user_id, id AS livestream_id FROM livestreams
+	UNION ALL
+	SELECT user_id, livestream_id FROM livestream_collaborators WHERE status = '%s'
+)`, collaboratorStatusAccepted)
+
+type LivestreamCollaboratorModel struct {
+	ID           int64         `db:"id"`
+	LivestreamID int64         `db:"livestream_id"`
+	UserID       int64         `db:"user_id"`
+	Status       string        `db:"status"`
+	CreatedAt    int64         `db:"created_at"`
+	RespondedAt  sql.NullInt64 `db:"responded_at"`
+}
+
+type LivestreamCollaborator struct {
+	ID           int64  `json:"id"`
+	LivestreamID int64  `json:"livestream_id"`
+	User         User   `json:"user"`
+	Status       string `json:"status"`
+	CreatedAt    int64  `json:"created_at"`
+	// 招待に応答していなければ省略
+	RespondedAt *int64 `json:"responded_at,omitempty"`
+}
+
+// (配信者向け)ライブ配信のコラボレーター一覧取得API。招待中・辞退も含む
+// GET /api/livestream/:livestream_id/collaborator
+func getLivestreamCollaboratorsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	var collaboratorModels []LivestreamCollaboratorModel
+	if err := tx.SelectContext(ctx, &collaboratorModels, "SELECT * FROM livestream_collaborators WHERE livestream_id = ? ORDER BY id", livestreamModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get collaborators: "+err.Error())
+	}
+
+	collaborators, err := fillLivestreamCollaboratorResponses(ctx, tx, collaboratorModels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill collaborators: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, collaborators)
+}
+
+// 自分宛てのコラボレーターの招待一覧取得API
+// GET /api/user/me/collaboration?status=invited
+func getMyCollaborationsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	query := "SELECT * FROM livestream_collaborators WHERE user_id = ?"
+	args := []interface{}{userID}
+	if status := c.QueryParam("status"); status != "" {
+		if status != collaboratorStatusInvited && status != collaboratorStatusAccepted && status != collaboratorStatusDeclined {
+			return echo.NewHTTPError(http.StatusBadRequest, "status must be invited, accepted or declined")
+		}
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC"
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var collaboratorModels []LivestreamCollaboratorModel
+	if err := tx.SelectContext(ctx, &collaboratorModels, query, args...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get collaborations: "+err.Error())
+	}
+
+	collaborators, err := fillLivestreamCollaboratorResponses(ctx, tx, collaboratorModels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill collaborations: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, collaborators)
+}
+
+// コラボレーターの招待承諾API
+// POST /api/livestream/:livestream_id/collaborator/accept
+func acceptCollaborationHandler(c echo.Context) error {
+	return respondCollaboration(c, collaboratorStatusAccepted)
+}
+
+// コラボレーターの招待辞退API
+// POST /api/livestream/:livestream_id/collaborator/decline
+func declineCollaborationHandler(c echo.Context) error {
+	return respondCollaboration(c, collaboratorStatusDeclined)
+}
+
+// respondCollaboration は自分宛ての招待中のコラボレーターをstatusにする
+func respondCollaboration(c echo.Context, status string) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var collaboratorModel LivestreamCollaboratorModel
+	if err := tx.GetContext(ctx, &collaboratorModel, "SELECT * FROM livestream_collaborators WHERE livestream_id = ? AND user_id = ? FOR UPDATE", livestreamID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "collaboration invitation not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get collaborator: "+err.Error())
+	}
+	if collaboratorModel.Status != collaboratorStatusInvited {
+		return echo.NewHTTPError(http.StatusConflict, "collaboration invitation is already "+collaboratorModel.Status)
+	}
+
+	now := time.Now().Unix()
+	if _, err := tx.ExecContext(ctx, "UPDATE livestream_collaborators SET status = ?, responded_at = ? WHERE id = ?", status, now, collaboratorModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update collaborator: "+err.Error())
+	}
+	collaboratorModel.Status = status
+	collaboratorModel.RespondedAt = sql.NullInt64{Int64: now, Valid: true}
+
+	collaborator, err := fillLivestreamCollaboratorResponse(ctx, tx, collaboratorModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill collaborator: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, collaborator)
+}
+
+// verifyCollaborators は予約時に指定されたコラボレーターのユーザ名を検証する
+func verifyCollaborators(ctx context.Context, tx *sqlx.Tx, ownerID int64, usernames []string) error {
+	if len(usernames) > maxLivestreamCollaborators {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("collaborators must be at most %d users", maxLivestreamCollaborators))
+	}
+	for _, username := range usernames {
+		var userModel UserModel
+		if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", username); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusBadRequest, "not found collaborator that has the given username: "+username)
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+		if userModel.ID == ownerID {
+			return echo.NewHTTPError(http.StatusBadRequest, "a streamer can't be a collaborator of own livestream")
+		}
+	}
+	return nil
+}
+
+// inviteCollaborators は配信にコラボレーターを招待し、招待したユーザに通知する。同じユーザは1度だけ招待する
+func inviteCollaborators(ctx context.Context, tx *sqlx.Tx, livestreamModel LivestreamModel, usernames []string) error {
+	now := time.Now().Unix()
+	for _, username := range usernames {
+		var userModel UserModel
+		if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", username); err != nil {
+			return err
+		}
+
+		rs, err := tx.ExecContext(ctx, "INSERT IGNORE INTO livestream_collaborators (livestream_id, user_id, status, created_at) VALUES (?, ?, ?, ?)", livestreamModel.ID, userModel.ID, collaboratorStatusInvited, now)
+		if err != nil {
+			return err
+		}
+		if n, err := rs.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			continue
+		}
+
+		if err := notifyUser(ctx, tx, userModel.ID, notificationTypeCollaboratorInvited, map[string]interface{}{
+			"livestream_id": livestreamModel.ID,
+			"streamer_id":   livestreamModel.UserID,
+			"title":         livestreamModel.Title,
+			"start_at":      livestreamModel.StartAt,
+			"end_at":        livestreamModel.EndAt,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAcceptedCollaborators は招待を承諾したコラボレーターを返す
+func getAcceptedCollaborators(ctx context.Context, tx *sqlx.Tx, livestreamID int64) ([]User, error) {
+	var userModels []UserModel
+	query := "SELECT u.* FROM livestream_collaborators c INNER JOIN users u ON u.id = c.user_id WHERE c.livestream_id = ? AND c.status = ? ORDER BY c.id"
+	if err := tx.SelectContext(ctx, &userModels, query, livestreamID, collaboratorStatusAccepted); err != nil {
+		return nil, err
+	}
+
+	users := make([]User, len(userModels))
+	for i := range userModels {
+		user, err := fillUserResponse(ctx, tx, userModels[i])
+		if err != nil {
+			return nil, err
+		}
+		users[i] = user
+	}
+	return users, nil
+}
+
+// isLivestreamCollaborator は招待を承諾したコラボレーターならtrueを返す
+func isLivestreamCollaborator(ctx context.Context, tx *sqlx.Tx, livestreamID int64, userID int64) (bool, error) {
+	var count int64
+	if err := tx.GetContext(ctx, &count, "SELECT COUNT(*) FROM livestream_collaborators WHERE livestream_id = ? AND user_id = ? AND status = ?", livestreamID, userID, collaboratorStatusAccepted); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func fillLivestreamCollaboratorResponses(ctx context.Context, tx *sqlx.Tx, collaboratorModels []LivestreamCollaboratorModel) ([]LivestreamCollaborator, error) {
+	collaborators := make([]LivestreamCollaborator, len(collaboratorModels))
+	for i := range collaboratorModels {
+		collaborator, err := fillLivestreamCollaboratorResponse(ctx, tx, collaboratorModels[i])
+		if err != nil {
+			return nil, err
+		}
+		collaborators[i] = collaborator
+	}
+	return collaborators, nil
+}
+
+func fillLivestreamCollaboratorResponse(ctx context.Context, tx *sqlx.Tx, collaboratorModel LivestreamCollaboratorModel) (LivestreamCollaborator, error) {
+	userModel := UserModel{}
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ?", collaboratorModel.UserID); err != nil {
+		return LivestreamCollaborator{}, err
+	}
+	user, err := fillUserResponse(ctx, tx, userModel)
+	if err != nil {
+		return LivestreamCollaborator{}, err
+	}
+
+	collaborator := LivestreamCollaborator{
+		ID:           collaboratorModel.ID,
+		LivestreamID: collaboratorModel.LivestreamID,
+		User:         user,
+		Status:       collaboratorModel.Status,
+		CreatedAt:    collaboratorModel.CreatedAt,
+	}
+	if collaboratorModel.RespondedAt.Valid {
+		collaborator.RespondedAt = &collaboratorModel.RespondedAt.Int64
+	}
+	return collaborator, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxLivestreamTitleLength       = 255
+	maxLivestreamDescriptionLength = 5000
+	maxLivestreamURLLength         = 255
+)
+
+// 指定されなかった項目は変更しない。tagsを指定するとタグをすべて置き換える
+type PatchLivestreamRequest struct {
+	Title        *string  `json:"title"`
+	Description  *string  `json:"description"`
+	PlaylistUrl  *string  `json:"playlist_url"`
+	ThumbnailUrl *string  `json:"thumbnail_url"`
+	Tags         *[]int64 `json:"tags"`
+}
+
+// (配信者向け)ライブ配信編集API
+// If-Matchに取得時のETagを指定し、その後に更新されていれば412を返す
+// PATCH /api/livestream/:livestream_id
+func patchLivestreamHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	ifMatch := c.Request().Header.Get("If-Match")
+	if ifMatch == "" {
+		return echo.NewHTTPError(http.StatusPreconditionRequired, "If-Match header is required")
+	}
+
+	var req *PatchLivestreamRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if err := validatePatchLivestreamRequest(req); err != nil {
+		return err
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	// バージョンを比べてから更新するまでに、他の更新が割り込まないようにロックする
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ? FOR UPDATE", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+	if livestreamModel.UserID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "can't edit other streamer's livestream")
+	}
+	if !livestreamETagMatches(ifMatch, livestreamModel) {
+		setLivestreamETag(c, livestreamModel)
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "livestream has been modified")
+	}
+
+	if req.Title != nil {
+		livestreamModel.Title = *req.Title
+	}
+	if req.Description != nil {
+		livestreamModel.Description = *req.Description
+	}
+	if req.PlaylistUrl != nil {
+		livestreamModel.PlaylistUrl = *req.PlaylistUrl
+	}
+	if req.ThumbnailUrl != nil {
+		livestreamModel.ThumbnailUrl = *req.ThumbnailUrl
+	}
+	livestreamModel.Version++
+
+	if _, err := tx.NamedExecContext(ctx, "UPDATE livestreams SET title = :title, description = :description, playlist_url = :playlist_url, thumbnail_url = :thumbnail_url, version = :version WHERE id = :id", livestreamModel); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livestream: "+err.Error())
+	}
+
+	if req.Tags != nil {
+		if err := replaceLivestreamTags(ctx, tx, livestreamModel.ID, *req.Tags); err != nil {
+			return err
+		}
+	}
+
+	livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	setLivestreamETag(c, livestreamModel)
+	return c.JSON(http.StatusOK, livestream)
+}
+
+func validatePatchLivestreamRequest(req *PatchLivestreamRequest) error {
+	if req.Title != nil {
+		if n := utf8.RuneCountInString(*req.Title); n == 0 || n > maxLivestreamTitleLength {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("title must be 1 to %d characters", maxLivestreamTitleLength))
+		}
+	}
+	if req.Description != nil && utf8.RuneCountInString(*req.Description) > maxLivestreamDescriptionLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("description must be at most %d characters", maxLivestreamDescriptionLength))
+	}
+	if req.PlaylistUrl != nil && !isValidLivestreamURL(*req.PlaylistUrl) {
+		return echo.NewHTTPError(http.StatusBadRequest, "playlist_url must be a http(s) URL")
+	}
+	if req.ThumbnailUrl != nil && !isValidLivestreamURL(*req.ThumbnailUrl) {
+		return echo.NewHTTPError(http.StatusBadRequest, "thumbnail_url must be a http(s) URL")
+	}
+	return nil
+}
+
+func isValidLivestreamURL(s string) bool {
+	if len(s) > maxLivestreamURLLength {
+		return false
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// replaceLivestreamTags は配信のタグをtagIDsで置き換える。同じタグは1つにまとめる
+func replaceLivestreamTags(ctx context.Context, tx *sqlx.Tx, livestreamID int64, tagIDs []int64) error {
+	uniqueTagIDs := make([]int64, 0, len(tagIDs))
+	seen := make(map[int64]struct{}, len(tagIDs))
+	for _, tagID := range tagIDs {
+		if _, ok := seen[tagID]; ok {
+			continue
+		}
+		seen[tagID] = struct{}{}
+		uniqueTagIDs = append(uniqueTagIDs, tagID)
+	}
+
+	if len(uniqueTagIDs) > 0 {
+		query, params, err := sqlx.In("SELECT COUNT(*) FROM tags WHERE id IN (?)", uniqueTagIDs)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to build query: "+err.Error())
+		}
+		var count int
+		if err := tx.GetContext(ctx, &count, tx.Rebind(query), params...); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to count tags: "+err.Error())
+		}
+		if count != len(uniqueTagIDs) {
+			return echo.NewHTTPError(http.StatusBadRequest, "tags contain unknown tag id")
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM livestream_tags WHERE livestream_id = ?", livestreamID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete livestream tags: "+err.Error())
+	}
+	for _, tagID := range uniqueTagIDs {
+		if _, err := tx.NamedExecContext(ctx, "INSERT INTO livestream_tags (livestream_id, tag_id) VALUES (:livestream_id, :tag_id)", &LivestreamTagModel{
+			LivestreamID: livestreamID,
+			TagID:        tagID,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream tag: "+err.Error())
+		}
+	}
+	return nil
+}
+
+func livestreamETag(livestreamModel LivestreamModel) string {
+	return strconv.Quote(strconv.FormatInt(livestreamModel.Version, 10))
+}
+
+// setLivestreamETag は配信のバージョンをETagとして返す。編集時にIf-Matchで指定させる
+func setLivestreamETag(c echo.Context, livestreamModel LivestreamModel) {
+	c.Response().Header().Set("ETag", livestreamETag(livestreamModel))
+}
+
+// livestreamETagMatches はIf-Matchのいずれかが配信のETagと一致すればtrueを返す
+func livestreamETagMatches(ifMatch string, livestreamModel LivestreamModel) bool {
+	etag := livestreamETag(livestreamModel)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		// 弱いETagは強い比較では一致しない
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
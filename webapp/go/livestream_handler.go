@@ -5,9 +5,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -23,6 +23,8 @@ type ReserveLivestreamRequest struct {
 	ThumbnailUrl string  `json:"thumbnail_url"`
 	StartAt      int64   `json:"start_at"`
 	EndAt        int64   `json:"end_at"`
+	// コラボレーターとして招待するユーザ名
+	Collaborators []string `json:"collaborators"`
 }
 
 type LivestreamViewerModel struct {
@@ -40,6 +42,10 @@ type LivestreamModel struct {
 	ThumbnailUrl string `db:"thumbnail_url" json:"thumbnail_url"`
 	StartAt      int64  `db:"start_at" json:"start_at"`
 	EndAt        int64  `db:"end_at" json:"end_at"`
+	// NULLなら初期データ。effectiveLivestreamStatusで状態を判断する
+	Status sql.NullString `db:"status" json:"-"`
+	// 更新のたびに増える。ETagとして返す
+	Version int64 `db:"version" json:"-"`
 }
 
 type Livestream struct {
@@ -52,6 +58,9 @@ type Livestream struct {
 	Tags         []Tag  `json:"tags"`
 	StartAt      int64  `json:"start_at"`
 	EndAt        int64  `json:"end_at"`
+	Status       string `json:"status"`
+	// 招待を承諾したコラボレーター
+	Collaborators []User `json:"collaborators"`
 }
 
 type LivestreamTagModel struct {
@@ -92,59 +101,79 @@ func reserveLivestreamHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	// 2023/11/25 10:00からの１年間の期間内であるかチェック
-	var (
-		termStartAt    = time.Date(2023, 11, 25, 1, 0, 0, 0, time.UTC)
-		termEndAt      = time.Date(2024, 11, 25, 1, 0, 0, 0, time.UTC)
-		reserveStartAt = time.Unix(req.StartAt, 0)
-		reserveEndAt   = time.Unix(req.EndAt, 0)
-	)
-	if (reserveStartAt.Equal(termEndAt) || reserveStartAt.After(termEndAt)) || (reserveEndAt.Equal(termStartAt) || reserveEndAt.Before(termStartAt)) {
-		return echo.NewHTTPError(http.StatusBadRequest, "bad reservation time range")
+	termModel, err := verifyReservationTerm(ctx, tx, req.StartAt, req.EndAt)
+	if err != nil {
+		return err
 	}
-
-	// 予約枠をみて、予約が可能か調べる
-	// NOTE: 並列な予約のoverbooking防止にFOR UPDATEが必要
-	var slots []*ReservationSlotModel
-	if err := tx.SelectContext(ctx, &slots, "SELECT * FROM reservation_slots WHERE start_at >= ? AND end_at <= ? FOR UPDATE", req.StartAt, req.EndAt); err != nil {
-		c.Logger().Warnf("予約枠一覧取得でエラー発生: %+v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+	if err := verifyCollaborators(ctx, tx, userID, req.Collaborators); err != nil {
+		return err
 	}
-	for _, slot := range slots {
-		var count int
-		if err := tx.GetContext(ctx, &count, "SELECT slot FROM reservation_slots WHERE start_at = ? AND end_at = ?", slot.StartAt, slot.EndAt); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
-		}
-		c.Logger().Infof("%d ~ %d予約枠の残数 = %d\n", slot.StartAt, slot.EndAt, slot.Slot)
-		if count < 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("予約期間 %d ~ %dに対して、予約区間 %d ~ %dが予約できません", termStartAt.Unix(), termEndAt.Unix(), req.StartAt, req.EndAt))
+
+	// waitlist=trueなら、予約枠が埋まっているときはキャンセル待ちに登録する
+	if c.QueryParam("waitlist") == "true" {
+		available, err := reservationSlotsAvailable(ctx, tx, req.StartAt, req.EndAt)
+		if err != nil {
+			// echo.NewHTTPErrorが返っているのでそのまま出力
+			return err
 		}
-	}
+		if !available {
+			entry, err := joinReservationWaitlist(ctx, tx, userID, req)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to join reservation waitlist: "+err.Error())
+			}
 
-	var (
-		livestreamModel = &LivestreamModel{
-			UserID:       int64(userID),
-			Title:        req.Title,
-			Description:  req.Description,
-			PlaylistUrl:  req.PlaylistUrl,
-			ThumbnailUrl: req.ThumbnailUrl,
-			StartAt:      req.StartAt,
-			EndAt:        req.EndAt,
+			if err := tx.Commit(); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+			}
+
+			return c.JSON(http.StatusAccepted, entry)
 		}
-	)
+	}
 
-	if _, err := tx.ExecContext(ctx, "UPDATE reservation_slots SET slot = slot - 1 WHERE start_at >= ? AND end_at <= ?", req.StartAt, req.EndAt); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation_slot: "+err.Error())
+	// 予約枠をみて、予約が可能なら確保する
+	if err := claimReservationSlots(ctx, tx, termModel, req.StartAt, req.EndAt); err != nil {
+		return err
 	}
 
-	rs, err := tx.NamedExecContext(ctx, "INSERT INTO livestreams (user_id, title, description, playlist_url, thumbnail_url, start_at, end_at) VALUES(:user_id, :title, :description, :playlist_url, :thumbnail_url, :start_at, :end_at)", livestreamModel)
+	livestreamModel, err := insertReservedLivestream(ctx, tx, userID, req)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream: "+err.Error())
 	}
 
+	livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, livestream)
+}
+
+// insertReservedLivestream は予約枠を確保した配信をタグと一緒に登録する
+func insertReservedLivestream(ctx context.Context, tx *sqlx.Tx, userID int64, req *ReserveLivestreamRequest) (LivestreamModel, error) {
+	livestreamModel := LivestreamModel{
+		UserID:       userID,
+		Title:        req.Title,
+		Description:  req.Description,
+		PlaylistUrl:  req.PlaylistUrl,
+		ThumbnailUrl: req.ThumbnailUrl,
+		StartAt:      req.StartAt,
+		EndAt:        req.EndAt,
+		Status:       sql.NullString{String: livestreamStatusScheduled, Valid: true},
+		Version:      1,
+	}
+
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO livestreams (user_id, title, description, playlist_url, thumbnail_url, start_at, end_at, status) VALUES(:user_id, :title, :description, :playlist_url, :thumbnail_url, :start_at, :end_at, :status)", livestreamModel)
+	if err != nil {
+		return LivestreamModel{}, err
+	}
+
 	livestreamID, err := rs.LastInsertId()
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted livestream id: "+err.Error())
+		return LivestreamModel{}, err
 	}
 	livestreamModel.ID = livestreamID
 
@@ -154,25 +183,76 @@ func reserveLivestreamHandler(c echo.Context) error {
 			LivestreamID: livestreamID,
 			TagID:        tagID,
 		}); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream tag: "+err.Error())
+			return LivestreamModel{}, err
 		}
 	}
 
-	livestream, err := fillLivestreamResponse(ctx, tx, *livestreamModel)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	if err := inviteCollaborators(ctx, tx, livestreamModel, req.Collaborators); err != nil {
+		return LivestreamModel{}, err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
-	}
+	return livestreamModel, nil
+}
 
-	return c.JSON(http.StatusCreated, livestream)
+// 配信検索の並び順
+const (
+	// 新しく予約された順
+	livestreamSortNewest = "newest"
+	// 開始時刻が早い順
+	livestreamSortStartingSoon = "starting_soon"
+	// 累計視聴者数が多い順
+	livestreamSortMostViewers = "most_viewers"
+	// リアクション数とチップ合計のスコアが高い順 (統計情報のランキングと同じスコア)
+	livestreamSortTopScore = "top_score"
+)
+
+// 配信検索の状態による絞り込み
+const (
+	livestreamFilterUpcoming = "upcoming"
+	livestreamFilterLive     = "live"
+	livestreamFilterEnded    = "ended"
+)
+
+// 並び順ごとのソートキーと、新しい順 (表示順) がキーの降順かどうか
+var livestreamSortKeys = map[string]struct {
+	expr string
+	desc bool
+}{
+	livestreamSortNewest:       {expr: "l.id", desc: true},
+	livestreamSortStartingSoon: {expr: "l.start_at", desc: false},
+	livestreamSortMostViewers:  {expr: "(SELECT COUNT(*) FROM livestream_viewers_history h WHERE h.livestream_id = l.id)", desc: true},
+	livestreamSortTopScore:     {expr: "(SELECT COUNT(*) FROM reactions r WHERE r.livestream_id = l.id) + (SELECT CAST(IFNULL(SUM(lc.tip), 0) AS SIGNED) FROM livecomments lc WHERE lc.livestream_id = l.id)", desc: true},
+}
+
+// ソートキーと一緒に取得した配信
+type livestreamSearchRow struct {
+	LivestreamModel
+	SortKey int64 `db:"sort_key"`
 }
 
+// ライブ配信検索API
+// GET /api/livestream/search?tag=a&tag=b&tag_mode=and&q=&owner=&status=live&start_at_from=&start_at_to=&sort=newest
 func searchLivestreamsHandler(c echo.Context) error {
 	ctx := c.Request().Context()
-	keyTagName := c.QueryParam("tag")
+
+	paging, err := parsePageParams(c)
+	if err != nil {
+		return err
+	}
+
+	sort := c.QueryParam("sort")
+	if sort == "" {
+		sort = livestreamSortNewest
+	}
+	sortKey, ok := livestreamSortKeys[sort]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "sort must be one of newest, starting_soon, most_viewers, top_score")
+	}
+
+	cond, args, err := livestreamSearchCondition(c)
+	if err != nil {
+		return err
+	}
 
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
@@ -180,66 +260,29 @@ func searchLivestreamsHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	var livestreamModels []*LivestreamModel
-	if c.QueryParam("tag") != "" {
-		// タグによる取得
-		// var tagIDList []int
-		// if err := tx.SelectContext(ctx, &tagIDList, "SELECT id FROM tags WHERE name = ?", keyTagName); err != nil {
-		// 	return echo.NewHTTPError(http.StatusInternalServerError, "failed to get tags: "+err.Error())
-		// }
-
-		// query, params, err := sqlx.In("SELECT * FROM livestream_tags WHERE tag_id IN (?) ORDER BY livestream_id DESC", tagIDList)
-		// if err != nil {
-		// 	return echo.NewHTTPError(http.StatusInternalServerError, "failed to construct IN query: "+err.Error())
-		// }
-		// var keyTaggedLivestreams []*LivestreamTagModel
-		// if err := tx.SelectContext(ctx, &keyTaggedLivestreams, query, params...); err != nil {
-		// 	return echo.NewHTTPError(http.StatusInternalServerError, "failed to get keyTaggedLivestreams: "+err.Error())
-		// }
-
-		// for _, keyTaggedLivestream := range keyTaggedLivestreams {
-		// 	ls := LivestreamModel{}
-		// 	if err := tx.GetContext(ctx, &ls, "SELECT * FROM livestreams WHERE id = ?", keyTaggedLivestream.LivestreamID); err != nil {
-		// 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
-		// }
-		query := `SELECT 
-			l.id,
-			l.user_id,
-			l.title,
-			l.description,
-			l.playlist_url,
-			l.thumbnail_url,
-			l.start_at,
-			l.end_at
-		FROM
-			livestreams l
-			LEFT JOIN livestream_tags lt ON l.id = lt.livestream_id
-			LEFT JOIN tags t ON lt.tag_id = t.id
-		WHERE t.name = ?
-		ORDER BY lt.livestream_id DESC`
-
-		if err := tx.SelectContext(ctx, &livestreamModels, query, keyTagName); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
-		}
-	} else {
-		// 検索条件なし
-		query := `SELECT * FROM livestreams ORDER BY id DESC`
-		if c.QueryParam("limit") != "" {
-			limit, err := strconv.Atoi(c.QueryParam("limit"))
-			if err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
-			}
-			query += fmt.Sprintf(" LIMIT %d", limit)
-		}
+	// 集計したソートキーでもカーソルで絞り込めるよう、派生テーブルにしてから並べる
+	keysetCond, order, keysetArgs := paging.keysetQuery("s.sort_key", "s.id", sortKey.desc)
+	query := "SELECT * FROM (SELECT l.*, " + sortKey.expr + " AS sort_key FROM livestreams l WHERE 1 = 1" + cond + ") s WHERE 1 = 1" + keysetCond + order
+	query, params, err := sqlx.In(query, append(args, keysetArgs...)...)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to construct IN query: "+err.Error())
+	}
 
-		if err := tx.SelectContext(ctx, &livestreamModels, query); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
-		}
+	var rows []*livestreamSearchRow
+	if err := tx.SelectContext(ctx, &rows, tx.Rebind(query), params...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
 	}
 
-	livestreams := make([]Livestream, len(livestreamModels))
-	for i := range livestreamModels {
-		livestream, err := fillLivestreamResponse(ctx, tx, *livestreamModels[i])
+	var page pageInfo
+	if paging.Paginated {
+		rows, page = paginate(paging, rows, func(row *livestreamSearchRow) pageCursor {
+			return pageCursor{Key: row.SortKey, ID: row.ID}
+		})
+	}
+
+	livestreams := make([]Livestream, len(rows))
+	for i := range rows {
+		livestream, err := fillLivestreamResponse(ctx, tx, rows[i].LivestreamModel)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
 		}
@@ -250,9 +293,102 @@ func searchLivestreamsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	if paging.Paginated {
+		return c.JSON(http.StatusOK, newPage(livestreams, page))
+	}
 	return c.JSON(http.StatusOK, livestreams)
 }
 
+// livestreamSearchCondition は検索条件のクエリパラメータを読み、livestreams lに対するWHERE句の条件を返す
+// タグ名の一覧はsqlx.Inで展開する
+func livestreamSearchCondition(c echo.Context) (string, []interface{}, error) {
+	var (
+		cond string
+		args []interface{}
+	)
+
+	// タグ (複数指定可)。tag_mode=andならすべてのタグ、orならいずれかのタグが付いた配信
+	var tagNames []string
+	seen := make(map[string]struct{})
+	for _, name := range c.QueryParams()["tag"] {
+		if _, ok := seen[name]; name == "" || ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		tagNames = append(tagNames, name)
+	}
+	if len(tagNames) > 0 {
+		switch c.QueryParam("tag_mode") {
+		case "", "or":
+			cond += " AND l.id IN (SELECT lt.livestream_id FROM livestream_tags lt INNER JOIN tags t ON t.id = lt.tag_id WHERE t.name IN (?))"
+			args = append(args, tagNames)
+		case "and":
+			cond += " AND l.id IN (SELECT lt.livestream_id FROM livestream_tags lt INNER JOIN tags t ON t.id = lt.tag_id WHERE t.name IN (?) GROUP BY lt.livestream_id HAVING COUNT(DISTINCT t.name) = ?)"
+			args = append(args, tagNames, len(tagNames))
+		default:
+			return "", nil, echo.NewHTTPError(http.StatusBadRequest, "tag_mode must be and or or")
+		}
+	}
+
+	// タイトル・説明文のキーワード
+	if q := strings.TrimSpace(c.QueryParam("q")); q != "" {
+		pattern := "%" + escapeLikePattern(q) + "%"
+		cond += " AND (l.title LIKE ? OR l.description LIKE ?)"
+		args = append(args, pattern, pattern)
+	}
+
+	// 配信者のユーザ名
+	if owner := c.QueryParam("owner"); owner != "" {
+		cond += " AND l.user_id = (SELECT id FROM users WHERE name = ?)"
+		args = append(args, owner)
+	}
+
+	// 配信の状態。状態を持たない初期データはeffectiveLivestreamStatusと同じく予約時間から判断する
+	if status := c.QueryParam("status"); status != "" {
+		now := time.Now().Unix()
+		switch status {
+		case livestreamFilterUpcoming:
+			cond += " AND (l.status = ? OR (l.status IS NULL AND l.start_at > ?))"
+			args = append(args, livestreamStatusScheduled, now)
+		case livestreamFilterLive:
+			cond += " AND (l.status = ? OR (l.status IS NULL AND l.start_at <= ? AND l.end_at > ?))"
+			args = append(args, livestreamStatusLive, now, now)
+		case livestreamFilterEnded:
+			cond += " AND (l.status = ? OR (l.status IS NULL AND l.end_at <= ?))"
+			args = append(args, livestreamStatusEnded, now)
+		default:
+			return "", nil, echo.NewHTTPError(http.StatusBadRequest, "status must be one of upcoming, live, ended")
+		}
+	}
+
+	// 開始時刻の範囲 [start_at_from, start_at_to)
+	for _, p := range []struct {
+		name string
+		op   string
+	}{
+		{name: "start_at_from", op: ">="},
+		{name: "start_at_to", op: "<"},
+	} {
+		v := c.QueryParam(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return "", nil, echo.NewHTTPError(http.StatusBadRequest, p.name+" query parameter must be integer")
+		}
+		cond += " AND l.start_at " + p.op + " ?"
+		args = append(args, t)
+	}
+
+	return cond, args, nil
+}
+
+// escapeLikePattern はLIKEのワイルドカードをエスケープする
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
 func getMyLivestreamsHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 	if err := verifyUserSession(c); err != nil {
@@ -357,6 +493,10 @@ func enterLivestreamHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
+	if err := verifyNotBanned(ctx, tx, int64(livestreamID), userID); err != nil {
+		return err
+	}
+
 	viewer := LivestreamViewerModel{
 		UserID:       int64(userID),
 		LivestreamID: int64(livestreamID),
@@ -367,10 +507,17 @@ func enterLivestreamHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream_view_history: "+err.Error())
 	}
 
+	var viewersCount int64
+	if err := tx.GetContext(ctx, &viewersCount, "SELECT COUNT(*) FROM livestream_viewers_history WHERE livestream_id = ?", livestreamID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to count livestream viewers: "+err.Error())
+	}
+
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	publishViewersCount(int64(livestreamID), viewersCount)
+
 	return c.NoContent(http.StatusOK)
 }
 
@@ -401,10 +548,17 @@ func exitLivestreamHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete livestream_view_history: "+err.Error())
 	}
 
+	var viewersCount int64
+	if err := tx.GetContext(ctx, &viewersCount, "SELECT COUNT(*) FROM livestream_viewers_history WHERE livestream_id = ?", livestreamID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to count livestream viewers: "+err.Error())
+	}
+
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	publishViewersCount(int64(livestreamID), viewersCount)
+
 	return c.NoContent(http.StatusOK)
 }
 
@@ -444,6 +598,7 @@ func getLivestreamHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	setLivestreamETag(c, livestreamModel)
 	return c.JSON(http.StatusOK, livestream)
 }
 
@@ -475,22 +630,49 @@ func getLivecommentReportsHandler(c echo.Context) error {
 	// existence already check
 	userID := sess.Values[defaultUserIDKey].(int64)
 
-	if livestreamModel.UserID != userID {
+	if ok, err := canModerateLivestream(ctx, tx, livestreamModel, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderator: "+err.Error())
+	} else if !ok {
 		return echo.NewHTTPError(http.StatusForbidden, "can't get other streamer's livecomment reports")
 	}
 
+	// statusを省略したら全件 (従来の挙動)
+	query := "SELECT * FROM livecomment_reports WHERE livestream_id = ?"
+	args := []interface{}{livestreamID}
+	if status := c.QueryParam("status"); status != "" {
+		if !isValidLivecommentReportStatus(status) {
+			return echo.NewHTTPError(http.StatusBadRequest, "status must be one of open, dismissed, actioned")
+		}
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+
 	var reportModels []*LivecommentReportModel
-	if err := tx.SelectContext(ctx, &reportModels, "SELECT * FROM livecomment_reports WHERE livestream_id = ?", livestreamID); err != nil {
+	if err := tx.SelectContext(ctx, &reportModels, query, args...); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment reports: "+err.Error())
 	}
 
-	reports := make([]LivecommentReport, len(reportModels))
-	for i := range reportModels {
-		report, err := fillLivecommentReportResponse(ctx, tx, *reportModels[i])
+	// group_by=livecommentならライブコメントごとにまとめて返す
+	if c.QueryParam("group_by") == "livecomment" {
+		models := make([]LivecommentReportModel, len(reportModels))
+		for i := range reportModels {
+			models[i] = *reportModels[i]
+		}
+		groups, err := groupLivecommentReports(ctx, tx, models)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment report: "+err.Error())
 		}
-		reports[i] = report
+
+		if err := tx.Commit(); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		}
+
+		return c.JSON(http.StatusOK, groups)
+	}
+
+	reports, err := fillLivecommentReportResponses(ctx, tx, reportModels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment report: "+err.Error())
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -528,16 +710,23 @@ func fillLivestreamResponse(ctx context.Context, tx *sqlx.Tx, livestreamModel Li
 		}
 	}
 
+	collaborators, err := getAcceptedCollaborators(ctx, tx, livestreamModel.ID)
+	if err != nil {
+		return Livestream{}, err
+	}
+
 	livestream := Livestream{
-		ID:           livestreamModel.ID,
-		Owner:        owner,
-		Title:        livestreamModel.Title,
-		Tags:         tags,
-		Description:  livestreamModel.Description,
-		PlaylistUrl:  livestreamModel.PlaylistUrl,
-		ThumbnailUrl: livestreamModel.ThumbnailUrl,
-		StartAt:      livestreamModel.StartAt,
-		EndAt:        livestreamModel.EndAt,
+		ID:            livestreamModel.ID,
+		Owner:         owner,
+		Title:         livestreamModel.Title,
+		Tags:          tags,
+		Description:   livestreamModel.Description,
+		PlaylistUrl:   livestreamModel.PlaylistUrl,
+		ThumbnailUrl:  livestreamModel.ThumbnailUrl,
+		StartAt:       livestreamModel.StartAt,
+		EndAt:         livestreamModel.EndAt,
+		Status:        effectiveLivestreamStatus(livestreamModel, time.Now().Unix()),
+		Collaborators: collaborators,
 	}
 	return livestream, nil
 }
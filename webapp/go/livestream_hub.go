@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+)
+
+const (
+	livestreamEventLivecomment         = "livecomment"
+	livestreamEventReaction            = "reaction"
+	livestreamEventLivecommentHidden   = "livecomment_hidden"
+	livestreamEventLivecommentRestored = "livecomment_restored"
+	livestreamEventViewers             = "viewers"
+	livestreamEventStatus              = "status"
+
+	// 1接続あたりに溜めておけるイベント数。溢れた接続は切断する
+	livestreamSubscriberBufferSize = 64
+)
+
+// ライブ配信ごとに購読者へイベントを配信するためのプロセス内ハブ
+var livestreamEvents = newLivestreamHub()
+
+type livestreamEvent struct {
+	// Last-Event-IDで再開するためのID (ライブコメントのID)
+	ID   int64       `json:"id,omitempty"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type livestreamSubscriber struct {
+	events chan livestreamEvent
+	// 送信が詰まって切り離されたときにcloseされる
+	dropped   chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *livestreamSubscriber) drop() {
+	s.closeOnce.Do(func() {
+		close(s.dropped)
+	})
+}
+
+type livestreamHub struct {
+	mu          sync.RWMutex
+	subscribers map[int64]map[*livestreamSubscriber]struct{}
+}
+
+func newLivestreamHub() *livestreamHub {
+	return &livestreamHub{
+		subscribers: make(map[int64]map[*livestreamSubscriber]struct{}),
+	}
+}
+
+func (h *livestreamHub) subscribe(livestreamID int64) *livestreamSubscriber {
+	sub := &livestreamSubscriber{
+		events:  make(chan livestreamEvent, livestreamSubscriberBufferSize),
+		dropped: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[livestreamID]; !ok {
+		h.subscribers[livestreamID] = make(map[*livestreamSubscriber]struct{})
+	}
+	h.subscribers[livestreamID][sub] = struct{}{}
+
+	return sub
+}
+
+func (h *livestreamHub) unsubscribe(livestreamID int64, sub *livestreamSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers[livestreamID], sub)
+	if len(h.subscribers[livestreamID]) == 0 {
+		delete(h.subscribers, livestreamID)
+	}
+	sub.drop()
+}
+
+// publish はブロックしない。受信が追いつかない購読者は切り離し、再接続で追いついてもらう
+func (h *livestreamHub) publish(livestreamID int64, event livestreamEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subscribers[livestreamID] {
+		select {
+		case sub.events <- event:
+		default:
+			sub.drop()
+		}
+	}
+}
+
+func publishViewersCount(livestreamID int64, viewersCount int64) {
+	livestreamEvents.publish(livestreamID, livestreamEvent{
+		Type: livestreamEventViewers,
+		Data: map[string]interface{}{
+			"viewers_count": viewersCount,
+		},
+	})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+type LivestreamSettingsModel struct {
+	LivestreamID        int64   `db:"livestream_id"`
+	NGWordStrictness    string  `db:"ng_word_strictness"`
+	ReportThresholdMode string  `db:"report_threshold_mode"`
+	ReportThreshold     float64 `db:"report_threshold"`
+	SlowModeSeconds     int64   `db:"slow_mode_seconds"`
+}
+
+type LivestreamSettings struct {
+	LivestreamID        int64   `json:"livestream_id"`
+	NGWordStrictness    string  `json:"ng_word_strictness"`
+	ReportThresholdMode string  `json:"report_threshold_mode"`
+	ReportThreshold     float64 `json:"report_threshold"`
+	// 0ならスローモードなし
+	SlowModeSeconds int64 `json:"slow_mode_seconds"`
+}
+
+// 指定されなかった項目は変更しない
+type PatchLivestreamSettingsRequest struct {
+	NGWordStrictness    *string  `json:"ng_word_strictness"`
+	ReportThresholdMode *string  `json:"report_threshold_mode"`
+	ReportThreshold     *float64 `json:"report_threshold"`
+	SlowModeSeconds     *int64   `json:"slow_mode_seconds"`
+}
+
+// 配信者向けライブ配信設定取得API
+// GET /api/livestream/:livestream_id/settings
+func getLivestreamSettingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+	if livestreamModel.UserID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "can't get other streamer's livestream settings")
+	}
+
+	settingsModel, err := getLivestreamSettings(ctx, tx, livestreamModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream settings: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, fillLivestreamSettingsResponse(settingsModel))
+}
+
+// 配信者向けライブ配信設定変更API
+// PATCH /api/livestream/:livestream_id/settings
+func patchLivestreamSettingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var req *PatchLivestreamSettingsRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.NGWordStrictness != nil && !isValidNGWordStrictness(*req.NGWordStrictness) {
+		return echo.NewHTTPError(http.StatusBadRequest, "ng_word_strictness must be one of exact, standard, strict")
+	}
+	if req.ReportThresholdMode != nil && !isValidReportThresholdMode(*req.ReportThresholdMode) {
+		return echo.NewHTTPError(http.StatusBadRequest, "report_threshold_mode must be one of none, count, score")
+	}
+	if req.ReportThreshold != nil && *req.ReportThreshold < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "report_threshold must not be negative")
+	}
+	if req.SlowModeSeconds != nil && (*req.SlowModeSeconds < 0 || *req.SlowModeSeconds > maxSlowModeSeconds) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("slow_mode_seconds must be between 0 and %d", maxSlowModeSeconds))
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+	if livestreamModel.UserID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "can't change other streamer's livestream settings")
+	}
+
+	settingsModel, err := getLivestreamSettings(ctx, tx, livestreamModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream settings: "+err.Error())
+	}
+	if req.NGWordStrictness != nil {
+		settingsModel.NGWordStrictness = *req.NGWordStrictness
+	}
+	if req.ReportThresholdMode != nil {
+		settingsModel.ReportThresholdMode = *req.ReportThresholdMode
+	}
+	if req.ReportThreshold != nil {
+		settingsModel.ReportThreshold = *req.ReportThreshold
+	}
+	if req.SlowModeSeconds != nil {
+		settingsModel.SlowModeSeconds = *req.SlowModeSeconds
+	}
+	// しきい値が0だと通報1件目で非表示になってしまう
+	if settingsModel.ReportThresholdMode != reportThresholdModeNone && settingsModel.ReportThreshold <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "report_threshold must be positive when report_threshold_mode is enabled")
+	}
+
+	query := `
+	INSERT INTO livestream_settings (livestream_id, ng_word_strictness, report_threshold_mode, report_threshold, slow_mode_seconds)
+	VALUES (:livestream_id, :ng_word_strictness, :report_threshold_mode, :report_threshold, :slow_mode_seconds)
+	ON DUPLICATE KEY UPDATE
+		ng_word_strictness = VALUES(ng_word_strictness),
+		report_threshold_mode = VALUES(report_threshold_mode),
+		report_threshold = VALUES(report_threshold),
+		slow_mode_seconds = VALUES(slow_mode_seconds)
+	`
+	if _, err := tx.NamedExecContext(ctx, query, settingsModel); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livestream settings: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	// 正規化の方法が変わるので、NGワードのマッチャーを作り直させる
+	ngWordMatchers.invalidate(livestreamModel.ID)
+
+	return c.JSON(http.StatusOK, fillLivestreamSettingsResponse(settingsModel))
+}
+
+// getLivestreamSettings は設定が保存されていなければデフォルト値を返す
+func getLivestreamSettings(ctx context.Context, tx *sqlx.Tx, livestreamID int64) (LivestreamSettingsModel, error) {
+	settingsModel := LivestreamSettingsModel{
+		LivestreamID:        livestreamID,
+		NGWordStrictness:    ngWordStrictnessExact,
+		ReportThresholdMode: reportThresholdModeNone,
+	}
+	if err := tx.GetContext(ctx, &settingsModel, "SELECT * FROM livestream_settings WHERE livestream_id = ?", livestreamID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return LivestreamSettingsModel{}, err
+	}
+	return settingsModel, nil
+}
+
+// verifySlowMode はスローモード中の配信で、前回の投稿から設定の秒数が経っていなければエラーを返す
+// 配信者とモデレーターには適用しない。投稿を保存できたら返り値のcommit、できなければcancelを呼ぶ
+func verifySlowMode(ctx context.Context, tx *sqlx.Tx, livestreamModel LivestreamModel, userID int64) (*slowModeReservation, error) {
+	settingsModel, err := getLivestreamSettings(ctx, tx, livestreamModel.ID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream settings: "+err.Error())
+	}
+	if settingsModel.SlowModeSeconds <= 0 {
+		return nil, nil
+	}
+	if ok, err := canModerateLivestream(ctx, tx, livestreamModel, userID); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderator: "+err.Error())
+	} else if ok {
+		return nil, nil
+	}
+	return slowModeLimiter.reserve(livestreamModel.ID, userID, time.Duration(settingsModel.SlowModeSeconds)*time.Second, time.Now())
+}
+
+func fillLivestreamSettingsResponse(settingsModel LivestreamSettingsModel) LivestreamSettings {
+	return LivestreamSettings{
+		LivestreamID:        settingsModel.LivestreamID,
+		NGWordStrictness:    settingsModel.NGWordStrictness,
+		ReportThresholdMode: settingsModel.ReportThresholdMode,
+		ReportThreshold:     settingsModel.ReportThreshold,
+		SlowModeSeconds:     settingsModel.SlowModeSeconds,
+	}
+}
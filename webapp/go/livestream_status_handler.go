@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// ライブ配信の状態
+const (
+	livestreamStatusScheduled = "scheduled"
+	livestreamStatusLive      = "live"
+	livestreamStatusEnded     = "ended"
+	livestreamStatusCancelled = "cancelled"
+)
+
+// effectiveLivestreamStatus は配信の状態を返す
+// 状態を持たない初期データは、予約時間から判断する
+func effectiveLivestreamStatus(livestreamModel LivestreamModel, now int64) string {
+	if livestreamModel.Status.Valid {
+		return livestreamModel.Status.String
+	}
+	switch {
+	case now < livestreamModel.StartAt:
+		return livestreamStatusScheduled
+	case now < livestreamModel.EndAt:
+		return livestreamStatusLive
+	default:
+		return livestreamStatusEnded
+	}
+}
+
+// verifyLivestreamLive は配信中でなければエラーにする。ライブコメントとリアクションは配信中しか受け付けない
+// 状態を持たない初期データも、レスポンスと同じく予約時間から判断する
+func verifyLivestreamLive(livestreamModel LivestreamModel) error {
+	status := effectiveLivestreamStatus(livestreamModel, time.Now().Unix())
+	if status == livestreamStatusLive {
+		return nil
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("livestream is not live (status: %s)", status))
+}
+
+// (配信者向け)配信開始API
+// POST /api/livestream/:livestream_id/start
+func startLivestreamHandler(c echo.Context) error {
+	return transitLivestreamStatus(c, livestreamStatusScheduled, livestreamStatusLive)
+}
+
+// (配信者向け)配信終了API
+// POST /api/livestream/:livestream_id/end
+func endLivestreamHandler(c echo.Context) error {
+	return transitLivestreamStatus(c, livestreamStatusLive, livestreamStatusEnded)
+}
+
+// (配信者向け)配信中止API。確保していた予約枠を返す
+// POST /api/livestream/:livestream_id/cancel
+func cancelLivestreamHandler(c echo.Context) error {
+	return transitLivestreamStatus(c, livestreamStatusScheduled, livestreamStatusCancelled)
+}
+
+// transitLivestreamStatus は配信をfromの状態からtoの状態へ遷移させる
+func transitLivestreamStatus(c echo.Context, from string, to string) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	// 同時に遷移させて予約枠を二重に返さないようにロックする
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ? FOR UPDATE", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+	if livestreamModel.UserID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "can't manage other streamer's livestream")
+	}
+
+	if status := effectiveLivestreamStatus(livestreamModel, time.Now().Unix()); status != from {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("livestream can't be %s while it is %s", to, status))
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE livestreams SET status = ?, version = version + 1 WHERE id = ?", to, livestreamModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livestream status: "+err.Error())
+	}
+	livestreamModel.Status = sql.NullString{String: to, Valid: true}
+	livestreamModel.Version++
+
+	if to == livestreamStatusCancelled {
+		if err := releaseReservationSlots(ctx, tx, livestreamModel.StartAt, livestreamModel.EndAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to release reservation slots: "+err.Error())
+		}
+		if err := promoteReservationWaitlist(ctx, tx, livestreamModel.StartAt, livestreamModel.EndAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to promote reservation waitlist: "+err.Error())
+		}
+	}
+
+	livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	livestreamEvents.publish(livestreamModel.ID, livestreamEvent{
+		Type: livestreamEventStatus,
+		Data: map[string]interface{}{
+			"status": to,
+		},
+	})
+
+	setLivestreamETag(c, livestreamModel)
+	return c.JSON(http.StatusOK, livestream)
+}
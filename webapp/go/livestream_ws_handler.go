@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	livestreamWebSocketHeartbeatInterval = 30 * time.Second
+	livestreamWebSocketWriteTimeout      = 10 * time.Second
+
+	livestreamWebSocketMessageError     = "error"
+	livestreamWebSocketMessageHeartbeat = "heartbeat"
+)
+
+// クライアントからWebSocketで送られてくるメッセージ
+type LivestreamWebSocketRequest struct {
+	// livecomment or reaction
+	Type      string `json:"type"`
+	Comment   string `json:"comment"`
+	Tip       int64  `json:"tip"`
+	EmojiName string `json:"emoji_name"`
+}
+
+// WebSocketで返すエラー。CodeはHTTPで返すときのステータスコード
+type LivestreamWebSocketError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// レート制限・スローモードのときだけ、次に投稿できるまでの秒数
+	RetryAfter int64 `json:"retry_after,omitempty"`
+}
+
+func newLivestreamWebSocketError(err error) *LivestreamWebSocketError {
+	var tme *tooManyRequestsError
+	if errors.As(err, &tme) {
+		return &LivestreamWebSocketError{Code: http.StatusTooManyRequests, Message: tme.message, RetryAfter: tme.retryAfterSeconds()}
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return &LivestreamWebSocketError{Code: he.Code, Message: fmt.Sprint(he.Message)}
+	}
+	return &LivestreamWebSocketError{Code: http.StatusInternalServerError, Message: err.Error()}
+}
+
+// ライブコメント・リアクション・モデレーション・視聴者数をまとめて配信するWebSocket
+// GET /api/livestream/:livestream_id/ws
+func livestreamWebSocketHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	server := websocket.Server{
+		Handshake: verifyWebSocketOrigin,
+		Handler: func(ws *websocket.Conn) {
+			serveLivestreamWebSocket(ws, userID, livestreamModel.ID)
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+
+	return nil
+}
+
+// セッションCookieで認証しているので、別オリジンからの接続は拒否する
+func verifyWebSocketOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host != req.Host {
+		return fmt.Errorf("origin %s is not allowed", origin)
+	}
+	config.Origin = u
+	return nil
+}
+
+func serveLivestreamWebSocket(ws *websocket.Conn, userID int64, livestreamID int64) {
+	defer ws.Close()
+	ctx := ws.Request().Context()
+
+	sub := livestreamEvents.subscribe(livestreamID)
+	defer livestreamEvents.unsubscribe(livestreamID, sub)
+
+	// 書き込みは配信用goroutineと受信ループの両方から行うので直列化する
+	var writeMu sync.Mutex
+	send := func(event livestreamEvent) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := ws.SetWriteDeadline(time.Now().Add(livestreamWebSocketWriteTimeout)); err != nil {
+			return err
+		}
+		return websocket.JSON.Send(ws, event)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		heartbeat := time.NewTicker(livestreamWebSocketHeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-sub.dropped:
+				// 受信が追いつかない接続は切断し、他の接続を巻き込まないようにする
+				ws.Close()
+				return
+			case <-heartbeat.C:
+				if err := send(livestreamEvent{Type: livestreamWebSocketMessageHeartbeat, Data: time.Now().Unix()}); err != nil {
+					ws.Close()
+					return
+				}
+			case event := <-sub.events:
+				if err := send(event); err != nil {
+					ws.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var req LivestreamWebSocketRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			return
+		}
+
+		// 投稿結果はハブ経由で自分にも配信されるので、ここではエラーだけ返す
+		var err error
+		switch req.Type {
+		case livestreamEventLivecomment:
+			_, err = insertLivecomment(ctx, userID, livestreamID, &PostLivecommentRequest{
+				Comment: req.Comment,
+				Tip:     req.Tip,
+			})
+		case livestreamEventReaction:
+			_, err = insertReaction(ctx, userID, livestreamID, &PostReactionRequest{
+				EmojiName: req.EmojiName,
+			})
+		default:
+			err = echo.NewHTTPError(http.StatusBadRequest, "unknown message type: "+req.Type)
+		}
+		if err != nil {
+			if sendErr := send(livestreamEvent{Type: livestreamWebSocketMessageError, Data: newLivestreamWebSocketError(err)}); sendErr != nil {
+				return
+			}
+		}
+	}
+}
@@ -119,6 +119,11 @@ func initializeHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to initialize: "+err.Error())
 	}
 
+	// DBを作り直したので、プロセス内のキャッシュも捨てる
+	ngWordMatchers.reset()
+	postRateLimiter.reset()
+	slowModeLimiter.reset()
+
 	c.Request().Header.Add("Content-Type", "application/json;charset=utf-8")
 	return c.JSON(http.StatusOK, InitializeResponse{
 		Language: "golang",
@@ -187,20 +192,68 @@ func main() {
 	e.GET("/api/user/:username/livestream", getUserLivestreamsHandler)
 	// get livestream
 	e.GET("/api/livestream/:livestream_id", getLivestreamHandler)
+	// (配信者向け)ライブ配信の編集 (If-Matchに取得時のETagを指定する)
+	e.PATCH("/api/livestream/:livestream_id", patchLivestreamHandler)
 	// get polling livecomment timeline
 	e.GET("/api/livestream/:livestream_id/livecomment", getLivecommentsHandler)
+	// ライブコメントのSSE配信
+	e.GET("/api/livestream/:livestream_id/livecomment/stream", streamLivecommentsHandler)
 	// ライブコメント投稿
 	e.POST("/api/livestream/:livestream_id/livecomment", postLivecommentHandler)
 	e.POST("/api/livestream/:livestream_id/reaction", postReactionHandler)
 	e.GET("/api/livestream/:livestream_id/reaction", getReactionsHandler)
+	// ライブコメント・リアクション・視聴者数のWebSocket配信
+	e.GET("/api/livestream/:livestream_id/ws", livestreamWebSocketHandler)
 
 	// (配信者向け)ライブコメントの報告一覧取得API
 	e.GET("/api/livestream/:livestream_id/report", getLivecommentReportsHandler)
 	e.GET("/api/livestream/:livestream_id/ngwords", getNgwords)
+	// NGワードを登録前に試す
+	e.POST("/api/livestream/:livestream_id/ngwords/test", testNGWordHandler)
+	// NGワードの編集・削除
+	e.PATCH("/api/livestream/:livestream_id/ngwords/:word_id", patchNGWordHandler)
+	e.DELETE("/api/livestream/:livestream_id/ngwords/:word_id", deleteNGWordHandler)
+	// 配信者の全配信向けNGワードを、配信ごとに除外する
+	e.PUT("/api/livestream/:livestream_id/ngwords/:word_id/exclusion", excludeStreamerNGWordHandler)
+	e.DELETE("/api/livestream/:livestream_id/ngwords/:word_id/exclusion", includeStreamerNGWordHandler)
 	// ライブコメント報告
 	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/report", reportLivecommentHandler)
 	// 配信者によるモデレーション (NGワード登録)
 	e.POST("/api/livestream/:livestream_id/moderate", moderateHandler)
+	// (配信者・モデレーター向け)ライブコメントの非表示・確認・復元
+	e.GET("/api/livestream/:livestream_id/livecomment/hidden", getHiddenLivecommentsHandler)
+	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/hide", hideLivecommentHandler)
+	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/restore", restoreLivecommentHandler)
+	// (配信者・モデレーター向け)ライブコメントへの通報の対応
+	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/report/resolve", resolveLivecommentReportsHandler)
+	// 通報で自動的に非表示にしたライブコメントの確認
+	e.GET("/api/livestream/:livestream_id/review", getLivecommentReviewItemsHandler)
+	e.POST("/api/livestream/:livestream_id/review/:review_item_id/resolve", resolveLivecommentReviewItemHandler)
+	// モデレーターの任命・解任 (配信者のみ)
+	e.GET("/api/livestream/:livestream_id/moderator", getLivestreamModeratorsHandler)
+	e.PUT("/api/livestream/:livestream_id/moderator/:username", putLivestreamModeratorHandler)
+	e.DELETE("/api/livestream/:livestream_id/moderator/:username", deleteLivestreamModeratorHandler)
+	// 視聴者のBAN・タイムアウト
+	e.GET("/api/livestream/:livestream_id/ban", getLivestreamBansHandler)
+	e.PUT("/api/livestream/:livestream_id/ban/:username", putLivestreamBanHandler)
+	e.DELETE("/api/livestream/:livestream_id/ban/:username", deleteLivestreamBanHandler)
+	// モデレーション操作の記録 (format=csvでCSV出力)
+	e.GET("/api/livestream/:livestream_id/moderation/log", getModerationLogHandler)
+	// 配信者によるライブ配信設定
+	e.GET("/api/livestream/:livestream_id/settings", getLivestreamSettingsHandler)
+	e.PATCH("/api/livestream/:livestream_id/settings", patchLivestreamSettingsHandler)
+
+	// (配信者向け)配信の開始・終了・中止
+	e.POST("/api/livestream/:livestream_id/start", startLivestreamHandler)
+	e.POST("/api/livestream/:livestream_id/end", endLivestreamHandler)
+	e.POST("/api/livestream/:livestream_id/cancel", cancelLivestreamHandler)
+	// (配信者向け)予約の取り消し・時間変更
+	e.DELETE("/api/livestream/:livestream_id/reservation", deleteReservationHandler)
+	e.PATCH("/api/livestream/:livestream_id/reservation", patchReservationHandler)
+	// 配信のコラボレーター (予約時にcollaboratorsで招待する)
+	e.GET("/api/livestream/:livestream_id/collaborator", getLivestreamCollaboratorsHandler)
+	e.POST("/api/livestream/:livestream_id/collaborator/accept", acceptCollaborationHandler)
+	e.POST("/api/livestream/:livestream_id/collaborator/decline", declineCollaborationHandler)
 
 	// livestream_viewersにINSERTするため必要
 	// ユーザ視聴開始 (viewer)
@@ -208,10 +261,43 @@ func main() {
 	// ユーザ視聴終了 (viewer)
 	e.DELETE("/api/livestream/:livestream_id/exit", exitLivestreamHandler)
 
+	// (配信者向け)予約のキャンセル待ち (予約時にwaitlist=trueで登録する)
+	e.GET("/api/user/me/reservation/waitlist", getReservationWaitlistHandler)
+	e.DELETE("/api/user/me/reservation/waitlist/:entry_id", deleteReservationWaitlistEntryHandler)
+	// 自分宛ての通知
+	e.GET("/api/user/me/notification", getNotificationsHandler)
+	e.POST("/api/user/me/notification/:notification_id/read", readNotificationHandler)
+	// 自分宛てのコラボレーターの招待
+	e.GET("/api/user/me/collaboration", getMyCollaborationsHandler)
+	// 配信とライブコメントの全文検索
+	e.GET("/api/search", searchHandler)
+	// 予約枠の空き状況
+	e.GET("/api/reservation/availability", getReservationAvailabilityHandler)
+
+	// (運営向け)予約期間と予約枠の管理
+	e.GET("/api/admin/reservation_term", getReservationTermsHandler)
+	e.POST("/api/admin/reservation_term", postReservationTermHandler)
+	e.DELETE("/api/admin/reservation_term/:term_id", deleteReservationTermHandler)
+	e.POST("/api/admin/reservation_term/:term_id/slots", generateReservationSlotsHandler)
+	e.PATCH("/api/admin/reservation_slots", adjustReservationSlotsHandler)
+
 	// user
 	e.POST("/api/register", registerHandler)
 	e.POST("/api/login", loginHandler)
 	e.GET("/api/user/me", getMeHandler)
+	// 配信者の全配信に適用するNGワード
+	e.GET("/api/user/me/ngwords", getStreamerNGWordsHandler)
+	e.POST("/api/user/me/ngwords", postStreamerNGWordHandler)
+	e.PATCH("/api/user/me/ngwords/:word_id", patchStreamerNGWordHandler)
+	e.DELETE("/api/user/me/ngwords/:word_id", deleteStreamerNGWordHandler)
+	// 配信者の全配信のモデレーター
+	e.GET("/api/user/me/moderator", getStreamerModeratorsHandler)
+	e.PUT("/api/user/me/moderator/:username", putStreamerModeratorHandler)
+	e.DELETE("/api/user/me/moderator/:username", deleteStreamerModeratorHandler)
+	// 配信者の全配信からのBAN・タイムアウト
+	e.GET("/api/user/me/ban", getStreamerBansHandler)
+	e.PUT("/api/user/me/ban/:username", putStreamerBanHandler)
+	e.DELETE("/api/user/me/ban/:username", deleteStreamerBanHandler)
 	// フロントエンドで、配信予約のコラボレーターを指定する際に必要
 	e.GET("/api/user/:username", getUserHandler)
 	e.GET("/api/user/:username/statistics", getUserStatisticsHandler)
@@ -258,6 +344,13 @@ type ErrorResponse struct {
 
 func errorResponseHandler(err error, c echo.Context) {
 	c.Logger().Errorf("error at %s: %+v", c.Path(), err)
+	if tme, ok := err.(*tooManyRequestsError); ok {
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(tme.retryAfterSeconds(), 10))
+		if e := c.JSON(http.StatusTooManyRequests, &ErrorResponse{Error: err.Error()}); e != nil {
+			c.Logger().Errorf("%+v", e)
+		}
+		return
+	}
 	if he, ok := err.(*echo.HTTPError); ok {
 		if e := c.JSON(he.Code, &ErrorResponse{Error: err.Error()}); e != nil {
 			c.Logger().Errorf("%+v", e)
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// モデレーション操作の種類
+const (
+	moderationActionNGWordAdded         = "ng_word_added"
+	moderationActionNGWordUpdated       = "ng_word_updated"
+	moderationActionNGWordRemoved       = "ng_word_removed"
+	moderationActionNGWordExcluded      = "ng_word_excluded"
+	moderationActionNGWordIncluded      = "ng_word_included"
+	moderationActionLivecommentHidden   = "livecomment_hidden"
+	moderationActionLivecommentRestored = "livecomment_restored"
+	moderationActionUserBanned          = "user_banned"
+	moderationActionUserUnbanned        = "user_unbanned"
+	moderationActionReportResolved      = "report_resolved"
+	moderationActionModeratorGranted    = "moderator_granted"
+	moderationActionModeratorRevoked    = "moderator_revoked"
+)
+
+// 操作の対象
+const (
+	moderationTargetNGWord      = "ng_word"
+	moderationTargetLivecomment = "livecomment"
+	moderationTargetUser        = "user"
+	moderationTargetReviewItem  = "review_item"
+)
+
+// 配信者の全配信に対する操作は、moderation_events.livestream_idをこの値にして記録する
+const streamerModerationLivestreamID = 0
+
+const (
+	// CSVで一度に書き出す最大件数
+	maxModerationLogExport = 100000
+	// CSVに書き出すときに一度に読み込む件数
+	moderationLogExportBatchSize = 1000
+)
+
+type ModerationEventModel struct {
+	ID           int64         `db:"id"`
+	StreamerID   int64         `db:"streamer_id"`
+	LivestreamID int64         `db:"livestream_id"`
+	ActorID      sql.NullInt64 `db:"actor_id"`
+	Action       string        `db:"action"`
+	TargetType   string        `db:"target_type"`
+	TargetID     int64         `db:"target_id"`
+	Detail       string        `db:"detail"`
+	CreatedAt    int64         `db:"created_at"`
+}
+
+type ModerationEvent struct {
+	ID int64 `json:"id"`
+	// 配信者の全配信に対する操作なら0
+	LivestreamID int64 `json:"livestream_id"`
+	// 自動で行われた操作では省略
+	Actor      *User           `json:"actor,omitempty"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int64           `json:"target_id"`
+	Detail     json.RawMessage `json:"detail"`
+	CreatedAt  int64           `json:"created_at"`
+}
+
+// (配信者・モデレーター向け)モデレーション操作の記録取得API
+// GET /api/livestream/:livestream_id/moderation/log
+// actor (ユーザ名), action, since, until (UNIX時間) で絞り込める。format=csvならCSVで返す
+func getModerationLogHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	exportCSV := c.QueryParam("format") == "csv"
+	paging, err := parsePageParams(c)
+	if err != nil {
+		return err
+	}
+	// 新しいAPIなので、常にページングする
+	if !paging.Paginated {
+		if paging.HasLimit && paging.Limit < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be positive")
+		}
+		paging.Paginated = true
+		if paging.Limit == 0 {
+			paging.Limit = defaultPageLimit
+		}
+	}
+	if paging.Limit > maxPageLimit {
+		paging.Limit = maxPageLimit
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	// 配信者の全配信に対する操作もこの配信に効くので含める
+	query := "SELECT * FROM moderation_events WHERE streamer_id = ? AND livestream_id IN (?, ?)"
+	args := []interface{}{livestreamModel.UserID, streamerModerationLivestreamID, livestreamModel.ID}
+
+	if actorName := c.QueryParam("actor"); actorName != "" {
+		var actorID int64
+		if err := tx.GetContext(ctx, &actorID, "SELECT id FROM users WHERE name = ?", actorName); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+		query += " AND actor_id = ?"
+		args = append(args, actorID)
+	}
+	if action := c.QueryParam("action"); action != "" {
+		query += " AND action = ?"
+		args = append(args, action)
+	}
+	for _, param := range []struct {
+		name string
+		cond string
+	}{
+		{"since", " AND created_at >= ?"},
+		{"until", " AND created_at < ?"},
+	} {
+		v := c.QueryParam(param.name)
+		if v == "" {
+			continue
+		}
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, param.name+" query parameter must be integer")
+		}
+		query += param.cond
+		args = append(args, t)
+	}
+
+	if exportCSV {
+		// 紛争の確認用なので、古い順にまとめて書き出す
+		return writeModerationLogCSV(c, tx, livestreamModel.ID, query, args)
+	}
+
+	var eventModels []ModerationEventModel
+	cond, order, pageArgs := paging.keysetQuery("created_at", "id", true)
+	if err := tx.SelectContext(ctx, &eventModels, query+cond+order, append(args, pageArgs...)...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get moderation events: "+err.Error())
+	}
+
+	eventModels, page := paginate(paging, eventModels, func(m ModerationEventModel) pageCursor {
+		return pageCursor{Key: m.CreatedAt, ID: m.ID}
+	})
+
+	events, err := fillModerationEventResponses(ctx, tx, eventModels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill moderation event: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, newPage(events, page))
+}
+
+// writeModerationLogCSV はqueryに当たる操作を古い順に、最大maxModerationLogExport件までCSVで書き出す
+// 全件を読み込まないよう、moderationLogExportBatchSize件ずつ読んでは書き出す
+// 最初の読み込みに失敗したらエラーを返すが、書き出し始めた後はJSONのエラーを混ぜないよう、ログに残して打ち切る
+func writeModerationLogCSV(c echo.Context, tx *sqlx.Tx, livestreamID int64, query string, args []interface{}) error {
+	ctx := c.Request().Context()
+
+	// CSVには操作したユーザのidと名前だけを書く
+	actorNames := map[int64]string{}
+	var (
+		written       int
+		lastCreatedAt int64
+		lastID        int64
+	)
+	loadEvents := func() ([]ModerationEventModel, error) {
+		pageQuery := query
+		pageArgs := args
+		if written > 0 {
+			pageQuery += " AND (created_at > ? OR (created_at = ? AND id > ?))"
+			pageArgs = append(append([]interface{}{}, args...), lastCreatedAt, lastCreatedAt, lastID)
+		}
+		pageQuery += " ORDER BY created_at ASC, id ASC LIMIT ?"
+		pageArgs = append(pageArgs, min(moderationLogExportBatchSize, maxModerationLogExport-written))
+
+		var eventModels []ModerationEventModel
+		if err := tx.SelectContext(ctx, &eventModels, pageQuery, pageArgs...); err != nil {
+			return nil, err
+		}
+		if err := loadModerationActorNames(ctx, tx, eventModels, actorNames); err != nil {
+			return nil, err
+		}
+		return eventModels, nil
+	}
+
+	eventModels, err := loadEvents()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get moderation events: "+err.Error())
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	res.Header().Set(echo.HeaderContentDisposition, "attachment; filename=\"moderation_log_"+strconv.FormatInt(livestreamID, 10)+".csv\"")
+	res.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(res)
+	if err := w.Write([]string{"id", "created_at", "livestream_id", "actor_id", "actor_name", "action", "target_type", "target_id", "detail"}); err != nil {
+		c.Logger().Errorf("failed to write moderation log csv: %v", err)
+		return nil
+	}
+
+	for {
+		for _, eventModel := range eventModels {
+			var actorID, actorName string
+			if eventModel.ActorID.Valid {
+				actorID = strconv.FormatInt(eventModel.ActorID.Int64, 10)
+				actorName = actorNames[eventModel.ActorID.Int64]
+			}
+			record := []string{
+				strconv.FormatInt(eventModel.ID, 10),
+				time.Unix(eventModel.CreatedAt, 0).UTC().Format(time.RFC3339),
+				strconv.FormatInt(eventModel.LivestreamID, 10),
+				actorID,
+				actorName,
+				eventModel.Action,
+				eventModel.TargetType,
+				strconv.FormatInt(eventModel.TargetID, 10),
+				eventModel.Detail,
+			}
+			if err := w.Write(record); err != nil {
+				c.Logger().Errorf("failed to write moderation log csv: %v", err)
+				return nil
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			c.Logger().Errorf("failed to write moderation log csv: %v", err)
+			return nil
+		}
+		res.Flush()
+
+		written += len(eventModels)
+		if len(eventModels) < moderationLogExportBatchSize || written >= maxModerationLogExport {
+			break
+		}
+		lastCreatedAt = eventModels[len(eventModels)-1].CreatedAt
+		lastID = eventModels[len(eventModels)-1].ID
+
+		eventModels, err = loadEvents()
+		if err != nil {
+			c.Logger().Errorf("failed to get moderation events: %v", err)
+			return nil
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Errorf("failed to commit: %v", err)
+	}
+	return nil
+}
+
+// loadModerationActorNames はactorNamesにまだないユーザの名前をまとめて読み込む
+func loadModerationActorNames(ctx context.Context, tx *sqlx.Tx, eventModels []ModerationEventModel, actorNames map[int64]string) error {
+	var actorIDs []int64
+	for _, eventModel := range eventModels {
+		if !eventModel.ActorID.Valid {
+			continue
+		}
+		if _, ok := actorNames[eventModel.ActorID.Int64]; ok {
+			continue
+		}
+		actorNames[eventModel.ActorID.Int64] = ""
+		actorIDs = append(actorIDs, eventModel.ActorID.Int64)
+	}
+	if len(actorIDs) == 0 {
+		return nil
+	}
+
+	query, params, err := sqlx.In("SELECT id, name FROM users WHERE id IN (?)", actorIDs)
+	if err != nil {
+		return err
+	}
+	var actors []struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+	if err := tx.SelectContext(ctx, &actors, tx.Rebind(query), params...); err != nil {
+		return err
+	}
+	for _, actor := range actors {
+		actorNames[actor.ID] = actor.Name
+	}
+	return nil
+}
+
+// recordModerationEvent はモデレーション操作を記録する。操作と同じトランザクションで呼ぶ
+// actorIDが0なら自動で行われた操作として記録する
+func recordModerationEvent(ctx context.Context, tx *sqlx.Tx, streamerID int64, livestreamID int64, actorID int64, action string, targetType string, targetID int64, detail map[string]interface{}) error {
+	if detail == nil {
+		detail = map[string]interface{}{}
+	}
+	b, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+
+	eventModel := ModerationEventModel{
+		StreamerID:   streamerID,
+		LivestreamID: livestreamID,
+		ActorID:      sql.NullInt64{Int64: actorID, Valid: actorID != 0},
+		Action:       action,
+		TargetType:   targetType,
+		TargetID:     targetID,
+		Detail:       string(b),
+		CreatedAt:    time.Now().Unix(),
+	}
+	_, err = tx.NamedExecContext(ctx, "INSERT INTO moderation_events (streamer_id, livestream_id, actor_id, action, target_type, target_id, detail, created_at) VALUES (:streamer_id, :livestream_id, :actor_id, :action, :target_type, :target_id, :detail, :created_at)", eventModel)
+	return err
+}
+
+// fillModerationEventResponses は操作したユーザをまとめて読み込み、同じユーザは一度だけ詰める
+func fillModerationEventResponses(ctx context.Context, tx *sqlx.Tx, eventModels []ModerationEventModel) ([]ModerationEvent, error) {
+	var actorIDs []int64
+	seen := map[int64]struct{}{}
+	for _, eventModel := range eventModels {
+		if !eventModel.ActorID.Valid {
+			continue
+		}
+		if _, ok := seen[eventModel.ActorID.Int64]; ok {
+			continue
+		}
+		seen[eventModel.ActorID.Int64] = struct{}{}
+		actorIDs = append(actorIDs, eventModel.ActorID.Int64)
+	}
+
+	actors := make(map[int64]*User, len(actorIDs))
+	if len(actorIDs) > 0 {
+		query, params, err := sqlx.In("SELECT * FROM users WHERE id IN (?)", actorIDs)
+		if err != nil {
+			return nil, err
+		}
+		var actorModels []UserModel
+		if err := tx.SelectContext(ctx, &actorModels, tx.Rebind(query), params...); err != nil {
+			return nil, err
+		}
+		for _, actorModel := range actorModels {
+			actor, err := fillUserResponse(ctx, tx, actorModel)
+			if err != nil {
+				return nil, err
+			}
+			actors[actor.ID] = &actor
+		}
+	}
+
+	events := make([]ModerationEvent, len(eventModels))
+	for i, eventModel := range eventModels {
+		events[i] = ModerationEvent{
+			ID:           eventModel.ID,
+			LivestreamID: eventModel.LivestreamID,
+			Action:       eventModel.Action,
+			TargetType:   eventModel.TargetType,
+			TargetID:     eventModel.TargetID,
+			Detail:       json.RawMessage(eventModel.Detail),
+			CreatedAt:    eventModel.CreatedAt,
+		}
+		if eventModel.ActorID.Valid {
+			actor, ok := actors[eventModel.ActorID.Int64]
+			if !ok {
+				return nil, sql.ErrNoRows
+			}
+			events[i].Actor = actor
+		}
+	}
+	return events, nil
+}
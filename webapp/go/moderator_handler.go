@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// 配信者の全配信のモデレーターは、livestream_moderators.livestream_idをこの値にして登録する
+const streamerModeratorLivestreamID = 0
+
+type LivestreamModeratorModel struct {
+	ID           int64 `db:"id"`
+	StreamerID   int64 `db:"streamer_id"`
+	LivestreamID int64 `db:"livestream_id"`
+	UserID       int64 `db:"user_id"`
+	CreatedAt    int64 `db:"created_at"`
+}
+
+type LivestreamModerator struct {
+	ID int64 `json:"id"`
+	// 配信者の全配信のモデレーターなら0
+	LivestreamID int64 `json:"livestream_id"`
+	User         User  `json:"user"`
+	CreatedAt    int64 `json:"created_at"`
+}
+
+// (配信者向け)ライブ配信のモデレーター一覧取得API
+// GET /api/livestream/:livestream_id/moderator
+func getLivestreamModeratorsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	moderators, err := getModerators(ctx, tx, userID, livestreamModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get moderators: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, moderators)
+}
+
+// (配信者向け)ライブ配信のモデレーター任命API
+// PUT /api/livestream/:livestream_id/moderator/:username
+func putLivestreamModeratorHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	moderator, err := grantModerator(ctx, tx, userID, livestreamModel.ID, c.Param("username"))
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, moderator)
+}
+
+// (配信者向け)ライブ配信のモデレーター解任API
+// DELETE /api/livestream/:livestream_id/moderator/:username
+func deleteLivestreamModeratorHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	if err := revokeModerator(ctx, tx, userID, livestreamModel.ID, c.Param("username")); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// (配信者向け)全配信のモデレーター一覧取得API
+// GET /api/user/me/moderator
+func getStreamerModeratorsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	moderators, err := getModerators(ctx, tx, userID, streamerModeratorLivestreamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get moderators: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, moderators)
+}
+
+// (配信者向け)全配信のモデレーター任命API
+// PUT /api/user/me/moderator/:username
+func putStreamerModeratorHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	moderator, err := grantModerator(ctx, tx, userID, streamerModeratorLivestreamID, c.Param("username"))
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, moderator)
+}
+
+// (配信者向け)全配信のモデレーター解任API
+// DELETE /api/user/me/moderator/:username
+func deleteStreamerModeratorHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	if err := revokeModerator(ctx, tx, userID, streamerModeratorLivestreamID, c.Param("username")); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// canModerateLivestream は配信者本人かこの配信のコラボレーター、またはこの配信か配信者の全配信のモデレーターならtrueを返す
+func canModerateLivestream(ctx context.Context, tx *sqlx.Tx, livestreamModel LivestreamModel, userID int64) (bool, error) {
+	if livestreamModel.UserID == userID {
+		return true, nil
+	}
+	if ok, err := isLivestreamCollaborator(ctx, tx, livestreamModel.ID, userID); err != nil || ok {
+		return ok, err
+	}
+	var count int64
+	query := "SELECT COUNT(*) FROM livestream_moderators WHERE streamer_id = ? AND user_id = ? AND livestream_id IN (?, ?)"
+	if err := tx.GetContext(ctx, &count, query, livestreamModel.UserID, userID, streamerModeratorLivestreamID, livestreamModel.ID); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// getModeratableLivestream は配信を取得し、ユーザがモデレートできなければエラーにする
+func getModeratableLivestream(ctx context.Context, tx *sqlx.Tx, livestreamID int64, userID int64) (LivestreamModel, error) {
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LivestreamModel{}, echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return LivestreamModel{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+	ok, err := canModerateLivestream(ctx, tx, livestreamModel, userID)
+	if err != nil {
+		return LivestreamModel{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderator: "+err.Error())
+	}
+	if !ok {
+		return LivestreamModel{}, echo.NewHTTPError(http.StatusForbidden, "A streamer can't moderate livestreams that other streamers own")
+	}
+	return livestreamModel, nil
+}
+
+// getOwnedLivestream は配信を取得し、配信者本人でなければエラーにする。モデレーターの任命は配信者本人しかできない
+func getOwnedLivestream(ctx context.Context, tx *sqlx.Tx, livestreamID int64, userID int64) (LivestreamModel, error) {
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LivestreamModel{}, echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return LivestreamModel{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+	if livestreamModel.UserID != userID {
+		return LivestreamModel{}, echo.NewHTTPError(http.StatusForbidden, "can't manage other streamer's livestream")
+	}
+	return livestreamModel, nil
+}
+
+func getModerators(ctx context.Context, tx *sqlx.Tx, streamerID int64, livestreamID int64) ([]LivestreamModerator, error) {
+	var moderatorModels []LivestreamModeratorModel
+	if err := tx.SelectContext(ctx, &moderatorModels, "SELECT * FROM livestream_moderators WHERE streamer_id = ? AND livestream_id = ? ORDER BY id", streamerID, livestreamID); err != nil {
+		return nil, err
+	}
+
+	moderators := make([]LivestreamModerator, len(moderatorModels))
+	for i := range moderatorModels {
+		moderator, err := fillLivestreamModeratorResponse(ctx, tx, moderatorModels[i])
+		if err != nil {
+			return nil, err
+		}
+		moderators[i] = moderator
+	}
+	return moderators, nil
+}
+
+func grantModerator(ctx context.Context, tx *sqlx.Tx, streamerID int64, livestreamID int64, username string) (LivestreamModerator, error) {
+	var userModel UserModel
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LivestreamModerator{}, echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+		}
+		return LivestreamModerator{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+	if userModel.ID == streamerID {
+		return LivestreamModerator{}, echo.NewHTTPError(http.StatusBadRequest, "a streamer can't be a moderator of own livestreams")
+	}
+
+	// 任命済みなら何もしない
+	rs, err := tx.ExecContext(ctx, "INSERT IGNORE INTO livestream_moderators (streamer_id, livestream_id, user_id, created_at) VALUES (?, ?, ?, ?)", streamerID, livestreamID, userModel.ID, time.Now().Unix())
+	if err != nil {
+		return LivestreamModerator{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to insert moderator: "+err.Error())
+	}
+	if n, err := rs.RowsAffected(); err != nil {
+		return LivestreamModerator{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get affected rows: "+err.Error())
+	} else if n > 0 {
+		if err := recordModerationEvent(ctx, tx, streamerID, livestreamID, streamerID, moderationActionModeratorGranted, moderationTargetUser, userModel.ID, nil); err != nil {
+			return LivestreamModerator{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+		}
+	}
+
+	var moderatorModel LivestreamModeratorModel
+	if err := tx.GetContext(ctx, &moderatorModel, "SELECT * FROM livestream_moderators WHERE streamer_id = ? AND livestream_id = ? AND user_id = ?", streamerID, livestreamID, userModel.ID); err != nil {
+		return LivestreamModerator{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get moderator: "+err.Error())
+	}
+	moderator, err := fillLivestreamModeratorResponse(ctx, tx, moderatorModel)
+	if err != nil {
+		return LivestreamModerator{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to fill moderator: "+err.Error())
+	}
+	return moderator, nil
+}
+
+func revokeModerator(ctx context.Context, tx *sqlx.Tx, streamerID int64, livestreamID int64, username string) error {
+	var userModel UserModel
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+
+	rs, err := tx.ExecContext(ctx, "DELETE FROM livestream_moderators WHERE streamer_id = ? AND livestream_id = ? AND user_id = ?", streamerID, livestreamID, userModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete moderator: "+err.Error())
+	}
+	if n, err := rs.RowsAffected(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get affected rows: "+err.Error())
+	} else if n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "moderator not found")
+	}
+	if err := recordModerationEvent(ctx, tx, streamerID, livestreamID, streamerID, moderationActionModeratorRevoked, moderationTargetUser, userModel.ID, nil); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+	return nil
+}
+
+func fillLivestreamModeratorResponse(ctx context.Context, tx *sqlx.Tx, moderatorModel LivestreamModeratorModel) (LivestreamModerator, error) {
+	userModel := UserModel{}
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ?", moderatorModel.UserID); err != nil {
+		return LivestreamModerator{}, err
+	}
+	user, err := fillUserResponse(ctx, tx, userModel)
+	if err != nil {
+		return LivestreamModerator{}, err
+	}
+
+	return LivestreamModerator{
+		ID:           moderatorModel.ID,
+		LivestreamID: moderatorModel.LivestreamID,
+		User:         user,
+		CreatedAt:    moderatorModel.CreatedAt,
+	}, nil
+}
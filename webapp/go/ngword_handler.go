@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultNGWordTestLimit = 100
+	maxNGWordTestLimit     = 1000
+)
+
+type TestNGWordRequest struct {
+	NGWord string `json:"ng_word"`
+	// 省略時はsubstring
+	MatchType string `json:"match_type"`
+	// 直近何件のライブコメントで試すか
+	Limit int `json:"limit"`
+}
+
+// 指定されなかった項目は変更しない
+type PatchNGWordRequest struct {
+	NGWord    *string `json:"ng_word"`
+	MatchType *string `json:"match_type"`
+}
+
+type UpdateNGWordResponse struct {
+	NGWord *NGWord `json:"ng_word"`
+	// 変更後のNGワードで新たに非表示にしたライブコメント
+	HiddenLivecommentIDs []int64 `json:"hidden_livecomment_ids"`
+	// restore_livecomments=trueのとき、元に戻したライブコメント
+	RestoredLivecommentIDs []int64 `json:"restored_livecomment_ids"`
+}
+
+type TestNGWordResponse struct {
+	TestedCount int64 `json:"tested_count"`
+	// 登録していたら非表示になっていたライブコメント
+	Matched []Livecomment `json:"matched"`
+}
+
+// NGワードを登録前に直近のライブコメントで試すAPI
+// POST /api/livestream/:livestream_id/ngwords/test
+func testNGWordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *TestNGWordRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.MatchType == "" {
+		req.MatchType = ngWordMatchTypeSubstring
+	}
+	if !isValidNGWordMatchType(req.MatchType) {
+		return echo.NewHTTPError(http.StatusBadRequest, "match_type must be one of substring, whole_word, regex, glob")
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultNGWordTestLimit
+	}
+	if req.Limit > maxNGWordTestLimit {
+		req.Limit = maxNGWordTestLimit
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	settingsModel, err := getLivestreamSettings(ctx, tx, livestreamModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream settings: "+err.Error())
+	}
+
+	rule, err := compileNGWordRule(&NGWord{
+		UserID:       livestreamModel.UserID,
+		LivestreamID: livestreamModel.ID,
+		Word:         req.NGWord,
+		MatchType:    req.MatchType,
+	}, settingsModel.NGWordStrictness)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid NG word: "+err.Error())
+	}
+
+	var livecommentModels []LivecommentModel
+	if err := tx.SelectContext(ctx, &livecommentModels, "SELECT * FROM livecomments WHERE livestream_id = ? AND hidden_at IS NULL ORDER BY created_at DESC, id DESC LIMIT ?", livestreamModel.ID, req.Limit); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomments: "+err.Error())
+	}
+
+	matched := []Livecomment{}
+	for i := range livecommentModels {
+		if !rule.matchText(livecommentModels[i].Comment, settingsModel.NGWordStrictness) {
+			continue
+		}
+		livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModels[i])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
+		}
+		matched = append(matched, livecomment)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &TestNGWordResponse{
+		TestedCount: int64(len(livecommentModels)),
+		Matched:     matched,
+	})
+}
+
+// NGワード編集API
+// PATCH /api/livestream/:livestream_id/ngwords/:word_id
+func patchNGWordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	wordID, err := strconv.Atoi(c.Param("word_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "word_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *PatchNGWordRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.MatchType != nil && !isValidNGWordMatchType(*req.MatchType) {
+		return echo.NewHTTPError(http.StatusBadRequest, "match_type must be one of substring, whole_word, regex, glob")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, ngword, err := getModeratableNGWord(ctx, tx, int64(livestreamID), int64(wordID), userID)
+	if err != nil {
+		return err
+	}
+
+	if req.NGWord != nil {
+		ngword.Word = *req.NGWord
+	}
+	if req.MatchType != nil {
+		ngword.MatchType = *req.MatchType
+	}
+
+	settingsModel, err := getLivestreamSettings(ctx, tx, livestreamModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream settings: "+err.Error())
+	}
+	if _, err := compileNGWordRule(ngword, settingsModel.NGWordStrictness); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid NG word: "+err.Error())
+	}
+
+	if _, err := tx.NamedExecContext(ctx, "UPDATE ng_words SET word = :word, match_type = :match_type WHERE id = :id", ngword); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update NG word: "+err.Error())
+	}
+	if err := recordModerationEvent(ctx, tx, livestreamModel.UserID, livestreamModel.ID, userID, moderationActionNGWordUpdated, moderationTargetNGWord, ngword.ID, map[string]interface{}{
+		"word":       ngword.Word,
+		"match_type": ngword.MatchType,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+
+	var restoredLivecommentIDs []int64
+	if c.QueryParam("restore_livecomments") == "true" {
+		restoredLivecommentIDs, err = restoreLivecommentsHiddenByNGWord(ctx, tx, livestreamModel, ngword.ID, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore livecomments: "+err.Error())
+		}
+	}
+
+	hiddenLivecommentIDs, err := hideLivecommentsMatchingNGWord(ctx, tx, livestreamModel.ID, ngword, settingsModel.NGWordStrictness, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide old livecomments that hit spams: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	ngWordMatchers.invalidate(livestreamModel.ID)
+	publishNGWordModeration(livestreamModel.ID, ngword.ID, hiddenLivecommentIDs, restoredLivecommentIDs)
+
+	return c.JSON(http.StatusOK, &UpdateNGWordResponse{
+		NGWord:                 ngword,
+		HiddenLivecommentIDs:   nonNilIDs(hiddenLivecommentIDs),
+		RestoredLivecommentIDs: nonNilIDs(restoredLivecommentIDs),
+	})
+}
+
+// NGワード削除API
+// DELETE /api/livestream/:livestream_id/ngwords/:word_id
+func deleteNGWordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	wordID, err := strconv.Atoi(c.Param("word_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "word_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, ngword, err := getModeratableNGWord(ctx, tx, int64(livestreamID), int64(wordID), userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ng_words WHERE id = ?", ngword.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete NG word: "+err.Error())
+	}
+	if err := recordModerationEvent(ctx, tx, livestreamModel.UserID, livestreamModel.ID, userID, moderationActionNGWordRemoved, moderationTargetNGWord, ngword.ID, map[string]interface{}{
+		"word":       ngword.Word,
+		"match_type": ngword.MatchType,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+
+	var restoredLivecommentIDs []int64
+	if c.QueryParam("restore_livecomments") == "true" {
+		restoredLivecommentIDs, err = restoreLivecommentsHiddenByNGWord(ctx, tx, livestreamModel, ngword.ID, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore livecomments: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	ngWordMatchers.invalidate(livestreamModel.ID)
+	publishNGWordModeration(livestreamModel.ID, ngword.ID, nil, restoredLivecommentIDs)
+
+	return c.JSON(http.StatusOK, &UpdateNGWordResponse{
+		NGWord:                 ngword,
+		HiddenLivecommentIDs:   []int64{},
+		RestoredLivecommentIDs: nonNilIDs(restoredLivecommentIDs),
+	})
+}
+
+// getModeratableNGWord は配信者またはモデレーターが、配信に登録されたNGワードを更新用にロックして取得する
+func getModeratableNGWord(ctx context.Context, tx *sqlx.Tx, livestreamID int64, wordID int64, userID int64) (LivestreamModel, *NGWord, error) {
+	livestreamModel, err := getModeratableLivestream(ctx, tx, livestreamID, userID)
+	if err != nil {
+		return LivestreamModel{}, nil, err
+	}
+
+	var ngword NGWord
+	if err := tx.GetContext(ctx, &ngword, "SELECT * FROM ng_words WHERE id = ? AND livestream_id = ? FOR UPDATE", wordID, livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LivestreamModel{}, nil, echo.NewHTTPError(http.StatusNotFound, "NG word not found")
+		} else {
+			return LivestreamModel{}, nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG word: "+err.Error())
+		}
+	}
+
+	return livestreamModel, &ngword, nil
+}
+
+// 過去のライブコメントを照合し直すときに、一度に読み込む件数
+const ngWordScanBatchSize = 1000
+
+// ngWordLikePrefilter は正規化せずにそのまま含むかで判定できるNGワードなら、DB側で絞り込むためのLIKEパターンを返す
+// 照合順序によっては大文字小文字の違うものも拾うので、最終的な判定はアプリ側で行う
+func ngWordLikePrefilter(ngword *NGWord, strictness string) (string, bool) {
+	if strictness != ngWordStrictnessExact {
+		return "", false
+	}
+	switch ngword.MatchType {
+	case ngWordMatchTypeSubstring, "", ngWordMatchTypeWholeWord:
+		return "%" + escapeLikePattern(ngword.Word) + "%", true
+	}
+	return "", false
+}
+
+// hideLivecommentsMatchingNGWord は配信の過去のライブコメントのうちNGワードに当たるものを非表示にする
+// 投稿時と同じ正規化で判定するため、正規化の要らないNGワードでLIKEで絞り込んだ上でアプリ側で照合する
+// 全件を一度に読み込まないよう、idの順に区切って処理する。hiddenByはNGワードを登録・編集したユーザ
+func hideLivecommentsMatchingNGWord(ctx context.Context, tx *sqlx.Tx, livestreamID int64, ngword *NGWord, strictness string, hiddenBy int64) ([]int64, error) {
+	matcher := newNGWordMatcher([]*NGWord{ngword}, strictness)
+
+	query := "SELECT * FROM livecomments WHERE livestream_id = ? AND hidden_at IS NULL AND id > ?"
+	likePattern, prefilter := ngWordLikePrefilter(ngword, strictness)
+	if prefilter {
+		query += " AND comment LIKE ?"
+	}
+	query += " ORDER BY id LIMIT ?"
+
+	var hiddenLivecommentIDs []int64
+	var lastID int64
+	for {
+		args := []interface{}{livestreamID, lastID}
+		if prefilter {
+			args = append(args, likePattern)
+		}
+		args = append(args, ngWordScanBatchSize)
+
+		var livecommentModels []*LivecommentModel
+		if err := tx.SelectContext(ctx, &livecommentModels, query, args...); err != nil {
+			return nil, err
+		}
+
+		var livecommentIDs []int64
+		for _, livecommentModel := range livecommentModels {
+			if matcher.match(livecommentModel.Comment) != nil {
+				livecommentIDs = append(livecommentIDs, livecommentModel.ID)
+			}
+		}
+		if err := hideLivecomments(ctx, tx, livecommentIDs, livecommentHiddenReasonNGWord, sql.NullInt64{Int64: ngword.ID, Valid: true}, sql.NullInt64{Int64: hiddenBy, Valid: true}); err != nil {
+			return nil, err
+		}
+		hiddenLivecommentIDs = append(hiddenLivecommentIDs, livecommentIDs...)
+
+		if len(livecommentModels) < ngWordScanBatchSize {
+			break
+		}
+		lastID = livecommentModels[len(livecommentModels)-1].ID
+	}
+	return hiddenLivecommentIDs, nil
+}
+
+// restoreLivecommentsHiddenByNGWord はwordIDのNGワードで非表示にしたライブコメントを、現在のNGワードで判定し直す
+// どのNGワードにも当たらなくなったものは元に戻し、他のNGワードに当たるものはそのNGワードによる非表示に付け替える
+// restoredByはNGワードを編集・削除したユーザ
+func restoreLivecommentsHiddenByNGWord(ctx context.Context, tx *sqlx.Tx, livestreamModel LivestreamModel, wordID int64, restoredBy int64) ([]int64, error) {
+	matcher, err := loadNGWordMatcher(ctx, tx, livestreamModel)
+	if err != nil {
+		return nil, err
+	}
+
+	var restoredLivecommentIDs []int64
+	var lastID int64
+	for {
+		var livecommentModels []*LivecommentModel
+		if err := tx.SelectContext(ctx, &livecommentModels, "SELECT * FROM livecomments WHERE livestream_id = ? AND hidden_reason = ? AND hidden_ng_word_id = ? AND id > ? ORDER BY id LIMIT ? FOR UPDATE", livestreamModel.ID, livecommentHiddenReasonNGWord, wordID, lastID, ngWordScanBatchSize); err != nil {
+			return nil, err
+		}
+
+		var livecommentIDs []int64
+		for _, livecommentModel := range livecommentModels {
+			ngword := matcher.match(livecommentModel.Comment)
+			if ngword == nil {
+				livecommentIDs = append(livecommentIDs, livecommentModel.ID)
+				continue
+			}
+			if ngword.ID != wordID {
+				if _, err := tx.ExecContext(ctx, "UPDATE livecomments SET hidden_ng_word_id = ? WHERE id = ?", ngword.ID, livecommentModel.ID); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := restoreLivecomments(ctx, tx, livecommentIDs, sql.NullInt64{Int64: restoredBy, Valid: true}); err != nil {
+			return nil, err
+		}
+		restoredLivecommentIDs = append(restoredLivecommentIDs, livecommentIDs...)
+
+		if len(livecommentModels) < ngWordScanBatchSize {
+			break
+		}
+		lastID = livecommentModels[len(livecommentModels)-1].ID
+	}
+	return restoredLivecommentIDs, nil
+}
+
+func publishNGWordModeration(livestreamID int64, wordID int64, hiddenLivecommentIDs []int64, restoredLivecommentIDs []int64) {
+	if len(hiddenLivecommentIDs) > 0 {
+		livestreamEvents.publish(livestreamID, livestreamEvent{
+			Type: livestreamEventLivecommentHidden,
+			Data: map[string]interface{}{
+				"livecomment_ids": hiddenLivecommentIDs,
+				"ng_word_id":      wordID,
+			},
+		})
+	}
+	if len(restoredLivecommentIDs) > 0 {
+		livestreamEvents.publish(livestreamID, livestreamEvent{
+			Type: livestreamEventLivecommentRestored,
+			Data: map[string]interface{}{
+				"livecomment_ids": restoredLivecommentIDs,
+			},
+		})
+	}
+}
+
+// JSONでnullではなく空配列を返すため
+func nonNilIDs(ids []int64) []int64 {
+	if ids == nil {
+		return []int64{}
+	}
+	return ids
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ライブ配信ごとのNGワードマッチャーのキャッシュ
+var ngWordMatchers = newNGWordMatcherCache()
+
+// ngWordMatcher は部分一致のNGワードをAho-Corasick法で一度に探し、それ以外のルールは個別に照合する
+// 構築後は読み取り専用なので、複数のリクエストから同時に使ってよい
+type ngWordMatcher struct {
+	// 配信者。配信者全体のNGワードが変わったときに、この配信者のマッチャーをまとめて捨てる
+	userID     int64
+	strictness string
+	words      []*NGWord
+	nodes      []ngWordMatcherNode
+	rules      []ngWordRule
+}
+
+type ngWordMatcherNode struct {
+	next map[byte]int32
+	fail int32
+	// このノードで終わるNGワード (wordsの添字)。failを辿った先の出力も含む
+	outputs []int32
+}
+
+func newNGWordMatcher(words []*NGWord, strictness string) *ngWordMatcher {
+	m := &ngWordMatcher{
+		strictness: strictness,
+		words:      words,
+		nodes:      []ngWordMatcherNode{{next: map[byte]int32{}}},
+	}
+
+	// 正規化したNGワードでトライ木を作る
+	for i, word := range words {
+		if word.MatchType != ngWordMatchTypeSubstring && word.MatchType != "" {
+			// 登録時に検証しているので、ここでコンパイルできないものは無視する
+			if rule, err := compileNGWordRule(word, strictness); err == nil {
+				m.rules = append(m.rules, rule)
+			}
+			continue
+		}
+		pattern := normalizeNGWordText(word.Word, strictness)
+		if pattern == "" {
+			continue
+		}
+		cur := int32(0)
+		for j := 0; j < len(pattern); j++ {
+			b := pattern[j]
+			n, ok := m.nodes[cur].next[b]
+			if !ok {
+				n = int32(len(m.nodes))
+				m.nodes = append(m.nodes, ngWordMatcherNode{next: map[byte]int32{}})
+				m.nodes[cur].next[b] = n
+			}
+			cur = n
+		}
+		m.nodes[cur].outputs = append(m.nodes[cur].outputs, int32(i))
+	}
+
+	// 幅優先で失敗遷移を張る
+	queue := make([]int32, 0, len(m.nodes))
+	for _, n := range m.nodes[0].next {
+		queue = append(queue, n)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for b, n := range m.nodes[cur].next {
+			f := m.nodes[cur].fail
+			for {
+				if to, ok := m.nodes[f].next[b]; ok && to != n {
+					m.nodes[n].fail = to
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = m.nodes[f].fail
+			}
+			m.nodes[n].outputs = append(m.nodes[n].outputs, m.nodes[m.nodes[n].fail].outputs...)
+			queue = append(queue, n)
+		}
+	}
+
+	return m
+}
+
+// withWord はNGワードを1つ足したマッチャーを返す。元のマッチャーは変更しない
+// 他のリクエストが使っている最中のマッチャーを書き換えないよう、手元のNGワードから作り直す (DBは読まない)
+func (m *ngWordMatcher) withWord(word *NGWord) *ngWordMatcher {
+	words := make([]*NGWord, len(m.words), len(m.words)+1)
+	copy(words, m.words)
+	added := newNGWordMatcher(append(words, word), m.strictness)
+	added.userID = m.userID
+	return added
+}
+
+// match はtextに含まれる最初のNGワードを返す。含まれなければnil
+func (m *ngWordMatcher) match(text string) *NGWord {
+	normalized := normalizeNGWordText(text, m.strictness)
+	cur := int32(0)
+	for i := 0; i < len(normalized); i++ {
+		b := normalized[i]
+		for {
+			if n, ok := m.nodes[cur].next[b]; ok {
+				cur = n
+				break
+			}
+			if cur == 0 {
+				break
+			}
+			cur = m.nodes[cur].fail
+		}
+		if len(m.nodes[cur].outputs) > 0 {
+			return m.words[m.nodes[cur].outputs[0]]
+		}
+	}
+	// 正規化の方法ごとに1回だけ正規化する
+	texts := map[int]string{ngWordRuleTextFolded: normalized}
+	for _, rule := range m.rules {
+		t, ok := texts[rule.text]
+		if !ok {
+			t = rule.normalizeText(text, m.strictness)
+			texts[rule.text] = t
+		}
+		if rule.match(t) {
+			return rule.word
+		}
+	}
+	return nil
+}
+
+// loadNGWordMatcher はキャッシュを使わずにng_wordsからマッチャーを作る
+// 配信ごとのNGワードと、この配信で除外されていない配信者全体のNGワードを合わせて使う
+func loadNGWordMatcher(ctx context.Context, tx *sqlx.Tx, livestreamModel LivestreamModel) (*ngWordMatcher, error) {
+	settingsModel, err := getLivestreamSettings(ctx, tx, livestreamModel.ID)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+	SELECT id, user_id, livestream_id, word, match_type FROM ng_words
+	WHERE user_id = ? AND (
+		livestream_id = ? OR
+		(livestream_id = ? AND id NOT IN (SELECT ng_word_id FROM ng_word_exclusions WHERE livestream_id = ?))
+	)
+	ORDER BY id
+	`
+	var ngwords []*NGWord
+	if err := tx.SelectContext(ctx, &ngwords, query, livestreamModel.UserID, livestreamModel.ID, streamerNGWordLivestreamID, livestreamModel.ID); err != nil {
+		return nil, err
+	}
+	m := newNGWordMatcher(ngwords, settingsModel.NGWordStrictness)
+	m.userID = livestreamModel.UserID
+	return m, nil
+}
+
+type ngWordMatcherCache struct {
+	mu       sync.RWMutex
+	matchers map[int64]*ngWordMatcher
+	// NGワードが変わるたびに増やす。DBから構築している間に変更があったら、古いマッチャーをキャッシュしない
+	generation uint64
+}
+
+func newNGWordMatcherCache() *ngWordMatcherCache {
+	return &ngWordMatcherCache{
+		matchers: make(map[int64]*ngWordMatcher),
+	}
+}
+
+// get はキャッシュになければng_wordsから構築する
+func (c *ngWordMatcherCache) get(ctx context.Context, tx *sqlx.Tx, livestreamModel LivestreamModel) (*ngWordMatcher, error) {
+	c.mu.RLock()
+	m, ok := c.matchers[livestreamModel.ID]
+	generation := c.generation
+	c.mu.RUnlock()
+	if ok {
+		return m, nil
+	}
+
+	m, err := loadNGWordMatcher(ctx, tx, livestreamModel)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 構築中に別のリクエストが入れていたらそちらを使う
+	if cached, ok := c.matchers[livestreamModel.ID]; ok {
+		return cached, nil
+	}
+	if c.generation == generation {
+		c.matchers[livestreamModel.ID] = m
+	}
+	return m, nil
+}
+
+// addWord はNGワード登録のコミット後に呼ぶ。未構築ならget時にDBから読むので何もしない
+func (c *ngWordMatcherCache) addWord(livestreamID int64, word *NGWord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if m, ok := c.matchers[livestreamID]; ok {
+		c.matchers[livestreamID] = m.withWord(word)
+	}
+}
+
+// invalidate は次のget時にDBから作り直させる
+func (c *ngWordMatcherCache) invalidate(livestreamID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.matchers, livestreamID)
+}
+
+// invalidateUser は配信者の全配信のマッチャーを捨てる
+func (c *ngWordMatcherCache) invalidateUser(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for livestreamID, m := range c.matchers {
+		if m.userID == userID {
+			delete(c.matchers, livestreamID)
+		}
+	}
+}
+
+func (c *ngWordMatcherCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.matchers = make(map[int64]*ngWordMatcher)
+}
@@ -0,0 +1,112 @@
+package main
+
+import "testing"
+
+func TestNGWordMatcherMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		words      []string
+		strictness string
+		text       string
+		// 一致するNGワード。一致しなければ空
+		want string
+	}{
+		{"no words", nil, ngWordStrictnessExact, "hello", ""},
+		{"no match", []string{"foo", "bar"}, ngWordStrictnessExact, "hello world", ""},
+		{"single", []string{"spam"}, ngWordStrictnessExact, "this is spam!", "spam"},
+		{"at start", []string{"spam"}, ngWordStrictnessExact, "spam here", "spam"},
+		{"at end", []string{"spam"}, ngWordStrictnessExact, "here is spam", "spam"},
+		{"whole text", []string{"spam"}, ngWordStrictnessExact, "spam", "spam"},
+		// 重なり合うNGワードは、先に終わるものを返す
+		{"overlapping", []string{"he", "she", "his", "hers"}, ngWordStrictnessExact, "ushers", "she"},
+		{"overlapping later word", []string{"abcx", "bcd"}, ngWordStrictnessExact, "abcd", "bcd"},
+		{"restart after mismatch", []string{"aab"}, ngWordStrictnessExact, "aaab", "aab"},
+		// 他のNGワードの接尾辞になっているNGワードは、失敗遷移の出力で見つける
+		{"suffix of other word", []string{"abcd", "bc"}, ngWordStrictnessExact, "xabcy", "bc"},
+		{"suffix registered first", []string{"bc", "abcd"}, ngWordStrictnessExact, "abcd", "bc"},
+		{"suffix at same end", []string{"abc", "c"}, ngWordStrictnessExact, "zzabc", "abc"},
+		{"multibyte", []string{"バカ"}, ngWordStrictnessExact, "お前はバカだ", "バカ"},
+		{"multibyte overlapping", []string{"あいう", "いえ"}, ngWordStrictnessExact, "あいえ", "いえ"},
+		{"multibyte suffix", []string{"ばかやろう", "やろう"}, ngWordStrictnessExact, "このやろう", "やろう"},
+		// UTF-8のバイト列の途中から一致させない
+		{"multibyte shared leading bytes", []string{"う"}, ngWordStrictnessExact, "あいえお", ""},
+		{"multibyte case sensitive in exact", []string{"ＳＰＡＭ"}, ngWordStrictnessExact, "spam", ""},
+		{"normalized full width", []string{"spam"}, ngWordStrictnessStandard, "ＳＰＡＭ", "spam"},
+		{"normalized spaces", []string{"spam"}, ngWordStrictnessStandard, "s p a m", "spam"},
+		{"strict kana", []string{"ばか"}, ngWordStrictnessStrict, "バカ", "ばか"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			words := make([]*NGWord, len(tt.words))
+			for i, w := range tt.words {
+				words[i] = &NGWord{ID: int64(i + 1), Word: w, MatchType: ngWordMatchTypeSubstring}
+			}
+			m := newNGWordMatcher(words, tt.strictness)
+
+			got := m.match(tt.text)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("match(%q) = %q, want no match", tt.text, got.Word)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("match(%q) = nil, want %q", tt.text, tt.want)
+			}
+			if got.Word != tt.want {
+				t.Errorf("match(%q) = %q, want %q", tt.text, got.Word, tt.want)
+			}
+		})
+	}
+}
+
+func TestNGWordMatcherWithWord(t *testing.T) {
+	m := newNGWordMatcher([]*NGWord{{ID: 1, Word: "abcd"}}, ngWordStrictnessExact)
+	added := m.withWord(&NGWord{ID: 2, Word: "bc"})
+
+	if got := added.match("xbcx"); got == nil || got.ID != 2 {
+		t.Errorf("added.match = %v, want word 2", got)
+	}
+	if got := added.match("abcd"); got == nil || got.ID != 2 {
+		t.Errorf("added.match = %v, want word 2", got)
+	}
+	// 元のマッチャーは変わらない
+	if got := m.match("xbcx"); got != nil {
+		t.Errorf("m.match = %q, want no match", got.Word)
+	}
+	if got := m.match("abcd"); got == nil || got.ID != 1 {
+		t.Errorf("m.match = %v, want word 1", got)
+	}
+}
+
+func TestNGWordMatcherRules(t *testing.T) {
+	words := []*NGWord{
+		{ID: 1, Word: "spam", MatchType: ngWordMatchTypeSubstring},
+		{ID: 2, Word: "ad", MatchType: ngWordMatchTypeWholeWord},
+		{ID: 3, Word: "^buy", MatchType: ngWordMatchTypeRegex},
+		{ID: 4, Word: "free*money", MatchType: ngWordMatchTypeGlob},
+	}
+	m := newNGWordMatcher(words, ngWordStrictnessStandard)
+
+	tests := []struct {
+		text string
+		want int64
+	}{
+		{"no problem", 0},
+		{"SPAM!", 1},
+		{"an ad here", 2},
+		{"reading", 0},
+		{"Buy now", 3},
+		{"please buy", 0},
+		{"free easy money", 4},
+	}
+	for _, tt := range tests {
+		got := m.match(tt.text)
+		switch {
+		case tt.want == 0 && got != nil:
+			t.Errorf("match(%q) = %d, want no match", tt.text, got.ID)
+		case tt.want != 0 && (got == nil || got.ID != tt.want):
+			t.Errorf("match(%q) = %v, want %d", tt.text, got, tt.want)
+		}
+	}
+}
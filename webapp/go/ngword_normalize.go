@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NGワード判定の厳しさ。NGワードとコメントの両方に同じ正規化をかけてから照合する
+const (
+	// 正規化しない (バイト列での部分一致)
+	ngWordStrictnessExact = "exact"
+	// NFKC・大文字小文字の同一視・空白とゼロ幅文字の除去
+	ngWordStrictnessStandard = "standard"
+	// standardに加えて、カタカナ/ひらがなの同一視と紛らわしい文字の置き換え
+	ngWordStrictnessStrict = "strict"
+)
+
+func isValidNGWordStrictness(strictness string) bool {
+	switch strictness {
+	case ngWordStrictnessExact, ngWordStrictnessStandard, ngWordStrictnessStrict:
+		return true
+	}
+	return false
+}
+
+// 見た目が似ている文字を、照合用の代表文字に寄せる (NFKCと小文字化の後にかける)
+var ngWordConfusables = map[rune]rune{
+	// キリル文字
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x',
+	'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	// ギリシャ文字
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	// 数字や記号による置き換え
+	'0': 'o', '1': 'l', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '|': 'l', 'ı': 'i',
+}
+
+// normalizeNGWordText は照合用の文字列を返す。表示や保存には使わない
+func normalizeNGWordText(text string, strictness string) string {
+	return normalizeNGWordTextWithSpaces(text, strictness, false)
+}
+
+// normalizeNGWordTextWithSpaces はkeepSpacesなら空白を除去せず残す (単語単位の照合用)
+func normalizeNGWordTextWithSpaces(text string, strictness string, keepSpaces bool) string {
+	if strictness == ngWordStrictnessExact || !isValidNGWordStrictness(strictness) {
+		return text
+	}
+
+	// 全角英数・半角カナなどをそろえてから大文字小文字を畳む
+	// Caserは状態を持つのでgoroutine間で共有せず、都度作る
+	text = cases.Fold().String(norm.NFKC.String(text))
+
+	var sb strings.Builder
+	sb.Grow(len(text))
+	for _, r := range text {
+		// 空白やゼロ幅文字を挟んだすり抜けを防ぐ
+		if (!keepSpaces && unicode.IsSpace(r)) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		if strictness == ngWordStrictnessStrict {
+			r = unifyKana(r)
+			if c, ok := ngWordConfusables[r]; ok {
+				r = c
+			}
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// normalizeNGWordPatternText は正規表現・ワイルドカードの照合用に、NFKCと大文字小文字の畳み込みだけをかける
+// 空白・数字・カタカナや紛らわしい文字はそのまま残す
+func normalizeNGWordPatternText(text string, strictness string) string {
+	if strictness == ngWordStrictnessExact || !isValidNGWordStrictness(strictness) {
+		return text
+	}
+	return cases.Fold().String(norm.NFKC.String(text))
+}
+
+// カタカナをひらがなに寄せる
+func unifyKana(r rune) rune {
+	switch {
+	case 'ァ' <= r && r <= 'ヶ':
+		return r - ('ァ' - 'ぁ')
+	case r == 'ヽ' || r == 'ヾ':
+		return r - ('ヽ' - 'ゝ')
+	}
+	return r
+}
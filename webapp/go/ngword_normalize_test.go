@@ -0,0 +1,117 @@
+package main
+
+import "testing"
+
+func TestNormalizeNGWordText(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		strictness string
+		want       string
+	}{
+		{"exact keeps text", "Ｓ p\u200bam", ngWordStrictnessExact, "Ｓ p\u200bam"},
+		{"unknown strictness keeps text", "SPAM", "loose", "SPAM"},
+		{"full width", "ＳＰＡＭ", ngWordStrictnessStandard, "spam"},
+		{"case fold", "SpAm", ngWordStrictnessStandard, "spam"},
+		{"spaces", "s p\ta　m", ngWordStrictnessStandard, "spam"},
+		{"zero width", "s\u200bp\u200da\ufeffm", ngWordStrictnessStandard, "spam"},
+		{"half width kana", "ｶﾀｶﾅ", ngWordStrictnessStandard, "カタカナ"},
+		{"standard keeps katakana", "カタカナ", ngWordStrictnessStandard, "カタカナ"},
+		{"standard keeps confusables", "sp4m", ngWordStrictnessStandard, "sp4m"},
+		{"strict kana", "カタカナ", ngWordStrictnessStrict, "かたかな"},
+		{"strict iteration mark", "ヽヾ", ngWordStrictnessStrict, "ゝゞ"},
+		{"strict confusables", "$p4m", ngWordStrictnessStrict, "spam"},
+		{"strict cyrillic", "ѕрам", ngWordStrictnessStrict, "spam"},
+		{"strict after nfkc", "ＳＰ４Ｍ", ngWordStrictnessStrict, "spam"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeNGWordText(tt.text, tt.strictness); got != tt.want {
+				t.Errorf("normalizeNGWordText(%q, %s) = %q, want %q", tt.text, tt.strictness, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeNGWordTextWithSpaces(t *testing.T) {
+	tests := []struct {
+		text       string
+		strictness string
+		want       string
+	}{
+		{"Ａ Ｂ", ngWordStrictnessStandard, "a b"},
+		{"a\u200bb c", ngWordStrictnessStandard, "ab c"},
+		{"カ ナ", ngWordStrictnessStrict, "か な"},
+	}
+	for _, tt := range tests {
+		if got := normalizeNGWordTextWithSpaces(tt.text, tt.strictness, true); got != tt.want {
+			t.Errorf("normalizeNGWordTextWithSpaces(%q, %s) = %q, want %q", tt.text, tt.strictness, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeNGWordPatternText(t *testing.T) {
+	tests := []struct {
+		text       string
+		strictness string
+		want       string
+	}{
+		{"ＦＲＥＥ ＭＯＮＥＹ", ngWordStrictnessExact, "ＦＲＥＥ ＭＯＮＥＹ"},
+		{"ＦＲＥＥ ＭＯＮＥＹ", ngWordStrictnessStandard, "free money"},
+		// 空白・数字・カタカナや紛らわしい文字はstrictでも残す
+		{"カネ 100 $", ngWordStrictnessStrict, "カネ 100 $"},
+		{"ｶﾈ", ngWordStrictnessStrict, "カネ"},
+	}
+	for _, tt := range tests {
+		if got := normalizeNGWordPatternText(tt.text, tt.strictness); got != tt.want {
+			t.Errorf("normalizeNGWordPatternText(%q, %s) = %q, want %q", tt.text, tt.strictness, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeNGWordRegexp(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		strictness string
+		text       string
+		want       bool
+	}{
+		{"ＳＰＡＭ", ngWordStrictnessExact, "spam", false},
+		{"ＳＰＡＭ", ngWordStrictnessStandard, "spam", true},
+		{"(ＢＵＹ|ｶﾈ)+", ngWordStrictnessStandard, "かね カネ", true},
+		{"^ｆｒｅｅ\\s+ＭＯＮＥＹ$", ngWordStrictnessStandard, "free   money", true},
+	}
+	for _, tt := range tests {
+		pattern, err := normalizeNGWordRegexp(tt.pattern, tt.strictness)
+		if err != nil {
+			t.Fatalf("normalizeNGWordRegexp(%q) error: %v", tt.pattern, err)
+		}
+		re, err := compileSafeRegexp(pattern)
+		if err != nil {
+			t.Fatalf("compileSafeRegexp(%q) error: %v", pattern, err)
+		}
+		if got := re.MatchString(normalizeNGWordPatternText(tt.text, tt.strictness)); got != tt.want {
+			t.Errorf("%q (%s) matches %q = %v, want %v", tt.pattern, pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestUnifyKana(t *testing.T) {
+	tests := []struct {
+		r    rune
+		want rune
+	}{
+		{'ア', 'あ'},
+		{'ァ', 'ぁ'},
+		{'ヶ', 'ゖ'},
+		{'ヽ', 'ゝ'},
+		{'ー', 'ー'},
+		{'あ', 'あ'},
+		{'a', 'a'},
+	}
+	for _, tt := range tests {
+		if got := unifyKana(tt.r); got != tt.want {
+			t.Errorf("unifyKana(%q) = %q, want %q", tt.r, got, tt.want)
+		}
+	}
+}
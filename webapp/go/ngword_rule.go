@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NGワードの照合方法
+const (
+	// 部分一致 (従来の挙動)
+	ngWordMatchTypeSubstring = "substring"
+	// 前後が文字・数字でない位置での一致
+	ngWordMatchTypeWholeWord = "whole_word"
+	// RE2の正規表現
+	ngWordMatchTypeRegex = "regex"
+	// * (任意の文字列) と ? (任意の1文字) のワイルドカード
+	ngWordMatchTypeGlob = "glob"
+)
+
+const (
+	// ng_words.wordのカラム長
+	maxNGWordLength = 255
+	// コンパイル後の正規表現の命令数の上限。巨大な繰り返しなどで重くなりすぎないようにする
+	maxNGWordRegexInsts = 2000
+)
+
+func isValidNGWordMatchType(matchType string) bool {
+	switch matchType {
+	case ngWordMatchTypeSubstring, ngWordMatchTypeWholeWord, ngWordMatchTypeRegex, ngWordMatchTypeGlob:
+		return true
+	}
+	return false
+}
+
+// ルールを当てるテキストの正規化の方法
+const (
+	// NGワードと同じ正規化 (空白の除去、strictではかなと紛らわしい文字の統一も)
+	ngWordRuleTextFolded = iota
+	// 空白を残した正規化。空白で単語を区切る完全一致用
+	ngWordRuleTextWithSpaces
+	// NFKCと大文字小文字の畳み込みだけ。正規表現とワイルドカード用
+	ngWordRuleTextPattern
+)
+
+// 部分一致以外のNGワード。Aho-Corasickに載せられないので個別に照合する
+type ngWordRule struct {
+	word *NGWord
+	// ngWordRuleText*のいずれか
+	text  int
+	match func(normalized string) bool
+}
+
+// normalizeText はこのルールを当てるためにテキストを正規化する
+func (r ngWordRule) normalizeText(text string, strictness string) string {
+	switch r.text {
+	case ngWordRuleTextWithSpaces:
+		return normalizeNGWordTextWithSpaces(text, strictness, true)
+	case ngWordRuleTextPattern:
+		return normalizeNGWordPatternText(text, strictness)
+	}
+	return normalizeNGWordText(text, strictness)
+}
+
+// matchText は正規化前のテキストに対して照合する
+func (r ngWordRule) matchText(text string, strictness string) bool {
+	return r.match(r.normalizeText(text, strictness))
+}
+
+// compileNGWordRule はNGワードを正規化済みのテキストに対する照合関数にする
+// 不正なNGワードはエラーにするので、登録前の検証にも使う
+func compileNGWordRule(word *NGWord, strictness string) (ngWordRule, error) {
+	if word.Word == "" {
+		return ngWordRule{}, fmt.Errorf("NG word must not be empty")
+	}
+	if utf8.RuneCountInString(word.Word) > maxNGWordLength {
+		return ngWordRule{}, fmt.Errorf("NG word must be at most %d characters", maxNGWordLength)
+	}
+
+	switch word.MatchType {
+	case ngWordMatchTypeSubstring, "":
+		pattern := normalizeNGWordText(word.Word, strictness)
+		return ngWordRule{word: word, match: func(text string) bool {
+			return pattern != "" && strings.Contains(text, pattern)
+		}}, nil
+	case ngWordMatchTypeWholeWord:
+		pattern := normalizeNGWordTextWithSpaces(word.Word, strictness, true)
+		return ngWordRule{word: word, text: ngWordRuleTextWithSpaces, match: func(text string) bool {
+			return containsWholeWord(text, pattern)
+		}}, nil
+	case ngWordMatchTypeRegex:
+		// 正規表現はNFKCと大文字小文字の畳み込みをかけたテキストに当てる (exactでは正規化しない)
+		// リテラルにも同じ正規化をかけ、(?i)を付ける。文字クラスの中は正規化しないので、半角・小文字で書く
+		// 空白・数字・カタカナは残るので、\sや[0-9]、カタカナの文字クラスも使える
+		pattern, err := normalizeNGWordRegexp(word.Word, strictness)
+		if err != nil {
+			return ngWordRule{}, err
+		}
+		re, err := compileSafeRegexp(pattern)
+		if err != nil {
+			return ngWordRule{}, err
+		}
+		return ngWordRule{word: word, text: ngWordRuleTextPattern, match: re.MatchString}, nil
+	case ngWordMatchTypeGlob:
+		re, err := compileSafeRegexp(globToRegexp(normalizeNGWordPatternText(word.Word, strictness)))
+		if err != nil {
+			return ngWordRule{}, err
+		}
+		return ngWordRule{word: word, text: ngWordRuleTextPattern, match: re.MatchString}, nil
+	}
+
+	return ngWordRule{}, fmt.Errorf("unknown match_type: %s", word.MatchType)
+}
+
+// compileSafeRegexp はRE2として解釈でき、大きさが上限以内の正規表現だけを受け付ける
+func compileSafeRegexp(pattern string) (*regexp.Regexp, error) {
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	if len(prog.Inst) > maxNGWordRegexInsts {
+		return nil, fmt.Errorf("regular expression is too complex")
+	}
+	return regexp.Compile(pattern)
+}
+
+// normalizeNGWordRegexp は正規表現のリテラルに、照合するテキストと同じ正規化をかける
+func normalizeNGWordRegexp(pattern string, strictness string) (string, error) {
+	if strictness == ngWordStrictnessExact || !isValidNGWordStrictness(strictness) {
+		return pattern, nil
+	}
+	parsed, err := syntax.Parse("(?i)"+pattern, syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("invalid regular expression: %w", err)
+	}
+	normalizeRegexpLiterals(parsed, strictness)
+	return parsed.String(), nil
+}
+
+func normalizeRegexpLiterals(re *syntax.Regexp, strictness string) {
+	if re.Op == syntax.OpLiteral {
+		re.Rune = []rune(normalizeNGWordPatternText(string(re.Rune), strictness))
+	}
+	for _, sub := range re.Sub {
+		normalizeRegexpLiterals(sub, strictness)
+	}
+}
+
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return sb.String()
+}
+
+// containsWholeWord はpatternの前後が文字・数字でない出現があるかを返す
+// 日本語は単語の区切りがないので、記号や空白で区切られている場合にだけ一致する
+func containsWholeWord(text, pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+	for offset := 0; offset <= len(text)-len(pattern); {
+		i := strings.Index(text[offset:], pattern)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(pattern)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !isWordRune(before) && !isWordRune(after) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(text[start:])
+		offset = start + size
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileNGWordRule(t *testing.T) {
+	tests := []struct {
+		name       string
+		word       string
+		matchType  string
+		strictness string
+		text       string
+		want       bool
+	}{
+		{"substring", "spam", ngWordMatchTypeSubstring, ngWordStrictnessExact, "xxspamxx", true},
+		{"substring normalized", "spam", ngWordMatchTypeSubstring, ngWordStrictnessStandard, "Ｓ Ｐ ＡＭ", true},
+
+		{"whole word", "ad", ngWordMatchTypeWholeWord, ngWordStrictnessStandard, "an ad.", true},
+		{"whole word inside word", "ad", ngWordMatchTypeWholeWord, ngWordStrictnessStandard, "reading", false},
+		{"whole word full width", "ad", ngWordMatchTypeWholeWord, ngWordStrictnessStandard, "an ＡＤ here", true},
+
+		{"regex", "^buy\\s+now", ngWordMatchTypeRegex, ngWordStrictnessStandard, "BUY  now", true},
+		{"regex keeps spaces", "buy\\s+now", ngWordMatchTypeRegex, ngWordStrictnessStandard, "buynow", false},
+		{"regex exact is case sensitive", "buy", ngWordMatchTypeRegex, ngWordStrictnessExact, "BUY", false},
+		// リテラルもテキストと同じく正規化するので、全角や大文字で書いても一致する
+		{"regex full width literal", "ＳＰＡＭ", ngWordMatchTypeRegex, ngWordStrictnessStandard, "spam", true},
+		{"regex full width literal in text", "ＳＰＡＭ", ngWordMatchTypeRegex, ngWordStrictnessStandard, "ｓｐａｍ", true},
+		{"regex literal with space", "free money", ngWordMatchTypeRegex, ngWordStrictnessStandard, "ＦＲＥＥ　ＭＯＮＥＹ", true},
+		{"regex half width kana literal", "ｶﾈ", ngWordMatchTypeRegex, ngWordStrictnessStandard, "カネ", true},
+		{"regex katakana class", "[ァ-ヶ]+", ngWordMatchTypeRegex, ngWordStrictnessStrict, "カタカナ", true},
+
+		{"glob", "free*money", ngWordMatchTypeGlob, ngWordStrictnessStandard, "FREE easy MONEY", true},
+		{"glob single", "b?d", ngWordMatchTypeGlob, ngWordStrictnessExact, "bad", true},
+		{"glob single needs one rune", "b?d", ngWordMatchTypeGlob, ngWordStrictnessExact, "bd", false},
+		{"glob multibyte single", "バ?", ngWordMatchTypeGlob, ngWordStrictnessExact, "バカ", true},
+		{"glob quotes metacharacters", "a.b", ngWordMatchTypeGlob, ngWordStrictnessExact, "axb", false},
+		{"glob full width", "ＦＲＥＥ*", ngWordMatchTypeGlob, ngWordStrictnessStandard, "free stuff", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := compileNGWordRule(&NGWord{Word: tt.word, MatchType: tt.matchType}, tt.strictness)
+			if err != nil {
+				t.Fatalf("compileNGWordRule(%q) error: %v", tt.word, err)
+			}
+			if got := rule.matchText(tt.text, tt.strictness); got != tt.want {
+				t.Errorf("matchText(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileNGWordRuleErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		word      string
+		matchType string
+	}{
+		{"empty", "", ngWordMatchTypeSubstring},
+		{"too long", strings.Repeat("a", maxNGWordLength+1), ngWordMatchTypeSubstring},
+		{"unknown match type", "spam", "prefix"},
+		{"invalid regex", "(spam", ngWordMatchTypeRegex},
+		{"backreference", `(a)\1`, ngWordMatchTypeRegex},
+		{"too complex regex", "(a{100}){100}", ngWordMatchTypeRegex},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, strictness := range []string{ngWordStrictnessExact, ngWordStrictnessStandard} {
+				if _, err := compileNGWordRule(&NGWord{Word: tt.word, MatchType: tt.matchType}, strictness); err == nil {
+					t.Errorf("compileNGWordRule(%q, %s) succeeded, want error", tt.word, strictness)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileSafeRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{"spam", false},
+		{"a{1000}", false},
+		{"a{1001}", true},
+		{"(ab){999}", true},
+		{"[", true},
+		{"(?P<x>a)", false},
+	}
+	for _, tt := range tests {
+		_, err := compileSafeRegexp(tt.pattern)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("compileSafeRegexp(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob string
+		want string
+	}{
+		{"abc", "abc"},
+		{"a*c", "a.*c"},
+		{"a?c", "a.c"},
+		{"a.c", `a\.c`},
+		{"(a)+[b]", `\(a\)\+\[b\]`},
+		{"バ*カ", "バ.*カ"},
+	}
+	for _, tt := range tests {
+		if got := globToRegexp(tt.glob); got != tt.want {
+			t.Errorf("globToRegexp(%q) = %q, want %q", tt.glob, got, tt.want)
+		}
+	}
+}
+
+func TestContainsWholeWord(t *testing.T) {
+	tests := []struct {
+		text    string
+		pattern string
+		want    bool
+	}{
+		{"ad", "ad", true},
+		{"an ad here", "ad", true},
+		{"ad!", "ad", true},
+		{"(ad)", "ad", true},
+		{"reading", "ad", false},
+		{"ads", "ad", false},
+		{"ad_", "ad", false},
+		{"ad1", "ad", false},
+		// 最初の出現が単語の途中でも、後ろの出現を探す
+		{"bad ad", "ad", true},
+		{"adad ad", "ad", true},
+		{"", "ad", false},
+		{"ad", "", false},
+		// 日本語は区切りがないので、前後が文字なら一致しない
+		{"お前はバカだ", "バカ", false},
+		{"「バカ」", "バカ", true},
+		{"バカ", "バカ", true},
+	}
+	for _, tt := range tests {
+		if got := containsWholeWord(tt.text, tt.pattern); got != tt.want {
+			t.Errorf("containsWholeWord(%q, %q) = %v, want %v", tt.text, tt.pattern, got, tt.want)
+		}
+	}
+}
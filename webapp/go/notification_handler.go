@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// 通知の種類
+const (
+	// キャンセル待ちから配信を予約できた
+	notificationTypeReservationPromoted = "reservation_promoted"
+	// 配信のコラボレーターに招待された
+	notificationTypeCollaboratorInvited = "collaborator_invited"
+)
+
+type NotificationModel struct {
+	ID        int64         `db:"id"`
+	UserID    int64         `db:"user_id"`
+	Type      string        `db:"type"`
+	Data      string        `db:"data"`
+	CreatedAt int64         `db:"created_at"`
+	ReadAt    sql.NullInt64 `db:"read_at"`
+}
+
+type Notification struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt int64           `json:"created_at"`
+	// 未読なら省略
+	ReadAt *int64 `json:"read_at,omitempty"`
+}
+
+// 自分宛ての通知一覧取得API
+// GET /api/user/me/notification?unread=true
+func getNotificationsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	query := "SELECT * FROM notifications WHERE user_id = ?"
+	if c.QueryParam("unread") == "true" {
+		query += " AND read_at IS NULL"
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	var notificationModels []NotificationModel
+	if err := tx.SelectContext(ctx, &notificationModels, query, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get notifications: "+err.Error())
+	}
+
+	notifications := make([]Notification, len(notificationModels))
+	for i := range notificationModels {
+		notifications[i] = fillNotificationResponse(notificationModels[i])
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, notifications)
+}
+
+// 通知を既読にするAPI
+// POST /api/user/me/notification/:notification_id/read
+func readNotificationHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	notificationID, err := strconv.Atoi(c.Param("notification_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "notification_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var notificationModel NotificationModel
+	if err := tx.GetContext(ctx, &notificationModel, "SELECT * FROM notifications WHERE id = ? AND user_id = ? FOR UPDATE", notificationID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "notification not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get notification: "+err.Error())
+	}
+	// 既読なら何もしない
+	if !notificationModel.ReadAt.Valid {
+		now := time.Now().Unix()
+		if _, err := tx.ExecContext(ctx, "UPDATE notifications SET read_at = ? WHERE id = ?", now, notificationModel.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update notification: "+err.Error())
+		}
+		notificationModel.ReadAt = sql.NullInt64{Int64: now, Valid: true}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, fillNotificationResponse(notificationModel))
+}
+
+// notifyUser はユーザ宛ての通知を登録する。通知のきっかけになった操作と同じトランザクションで呼ぶ
+func notifyUser(ctx context.Context, tx *sqlx.Tx, userID int64, notificationType string, data map[string]interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO notifications (user_id, type, data, created_at) VALUES (?, ?, ?, ?)", userID, notificationType, string(b), time.Now().Unix())
+	return err
+}
+
+func fillNotificationResponse(notificationModel NotificationModel) Notification {
+	notification := Notification{
+		ID:        notificationModel.ID,
+		Type:      notificationModel.Type,
+		Data:      json.RawMessage(notificationModel.Data),
+		CreatedAt: notificationModel.CreatedAt,
+	}
+	if notificationModel.ReadAt.Valid {
+		notification.ReadAt = &notificationModel.ReadAt.Int64
+	}
+	return notification
+}
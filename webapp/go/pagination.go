@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// ページングのレスポンス
+type Page[T any] struct {
+	Items []T `json:"items"`
+	// 同じ向きに続きを取得するためのカーソル
+	NextCursor string `json:"next_cursor,omitempty"`
+	// 逆向きに取得するためのカーソル
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+type pageInfo struct {
+	NextCursor string
+	PrevCursor string
+	HasMore    bool
+}
+
+// (ソートキー, id) の組で位置を表すカーソル。クライアントには不透明な文字列として渡す
+type pageCursor struct {
+	Key int64
+	ID  int64
+}
+
+func (p pageCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", p.Key, p.ID)))
+}
+
+func decodePageCursor(s string) (pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, err
+	}
+	key, id, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return pageCursor{}, fmt.Errorf("malformed cursor")
+	}
+	var cursor pageCursor
+	if cursor.Key, err = strconv.ParseInt(key, 10, 64); err != nil {
+		return pageCursor{}, err
+	}
+	if cursor.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return pageCursor{}, err
+	}
+	return cursor, nil
+}
+
+type pageParams struct {
+	Limit int
+	// limitが指定されたか。ページングしない従来のリクエストで指定がなければ件数制限なし
+	// 従来のリクエストでは、0以下のlimitも以前と同じくそのままLIMITに渡す
+	HasLimit bool
+	Cursor   *pageCursor
+	// trueならカーソルより新しい側 (after) を取得する
+	After bool
+	// cursor/before/afterのいずれかが指定されたらPageで返す
+	Paginated bool
+}
+
+// parsePageParams は limit, cursor, before, after クエリパラメータを読む
+// cursorとbeforeは同じ意味で、カーソルより古い側を取得する
+func parsePageParams(c echo.Context) (pageParams, error) {
+	var p pageParams
+
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return pageParams{}, echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
+		}
+		p.Limit = limit
+		p.HasLimit = true
+	}
+
+	query := c.QueryParams()
+	var token string
+	for _, key := range []string{"cursor", "before", "after"} {
+		if _, ok := query[key]; !ok {
+			continue
+		}
+		if p.Paginated {
+			return pageParams{}, echo.NewHTTPError(http.StatusBadRequest, "only one of cursor, before and after can be specified")
+		}
+		p.Paginated = true
+		p.After = key == "after"
+		token = query.Get(key)
+	}
+	if !p.Paginated {
+		return p, nil
+	}
+	if p.HasLimit && p.Limit < 1 {
+		return pageParams{}, echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be positive")
+	}
+
+	if token != "" {
+		cursor, err := decodePageCursor(token)
+		if err != nil {
+			return pageParams{}, echo.NewHTTPError(http.StatusBadRequest, "invalid cursor")
+		}
+		p.Cursor = &cursor
+	}
+	if p.Limit == 0 {
+		p.Limit = defaultPageLimit
+	}
+	if p.Limit > maxPageLimit {
+		p.Limit = maxPageLimit
+	}
+
+	return p, nil
+}
+
+// keysetQuery はWHERE句に足す条件と、ORDER BY/LIMIT句を返す
+// desc は新しい順 (通常の表示順) がキーの降順かどうか
+func (p pageParams) keysetQuery(keyColumn, idColumn string, desc bool) (string, string, []interface{}) {
+	var (
+		cond string
+		args []interface{}
+	)
+	// afterのときは表示順と逆向きに読んで、後で並べ直す
+	asc := desc == p.After
+	if p.Cursor != nil {
+		op := "<"
+		if asc {
+			op = ">"
+		}
+		cond = fmt.Sprintf(" AND (%[1]s %[3]s ? OR (%[1]s = ? AND %[2]s %[3]s ?))", keyColumn, idColumn, op)
+		args = append(args, p.Cursor.Key, p.Cursor.Key, p.Cursor.ID)
+	}
+
+	dir := "DESC"
+	if asc {
+		dir = "ASC"
+	}
+	order := fmt.Sprintf(" ORDER BY %s %s, %s %s", keyColumn, dir, idColumn, dir)
+	if p.Paginated || p.HasLimit {
+		order += " LIMIT ?"
+		limit := p.Limit
+		if p.Paginated {
+			// 続きがあるか判定するため1件多く取る
+			limit++
+		}
+		args = append(args, limit)
+	}
+
+	return cond, order, args
+}
+
+// paginate はkeysetQueryで取得した行を表示順に並べ、カーソルを計算する
+func paginate[T any](p pageParams, rows []T, cursorOf func(T) pageCursor) ([]T, pageInfo) {
+	var page pageInfo
+	if len(rows) > p.Limit {
+		rows = rows[:p.Limit]
+		page.HasMore = true
+	}
+	if p.After {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if len(rows) == 0 {
+		return rows, page
+	}
+
+	first, last := cursorOf(rows[0]), cursorOf(rows[len(rows)-1])
+	if p.After {
+		// 新しい側へ進むので、次は先頭 (最新) から
+		page.NextCursor = first.String()
+		page.PrevCursor = last.String()
+	} else {
+		if page.HasMore {
+			page.NextCursor = last.String()
+		}
+		page.PrevCursor = first.String()
+	}
+
+	return rows, page
+}
+
+func newPage[T any](items []T, info pageInfo) Page[T] {
+	return Page[T]{
+		Items:      items,
+		NextCursor: info.NextCursor,
+		PrevCursor: info.PrevCursor,
+		HasMore:    info.HasMore,
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// ライブコメントとリアクションの投稿は、ユーザごとに毎秒postRateLimitPerSecond回まで (最大postRateLimitBurst回まで連続で投稿できる)
+	postRateLimitPerSecond = 5
+	postRateLimitBurst     = 20
+	// スローモードで設定できる最大の秒数
+	maxSlowModeSeconds = 3600
+	// これより長く使われていない状態は捨てる
+	rateLimiterIdleTTL = 10 * time.Minute
+)
+
+// 全配信共通の投稿のレート制限
+var postRateLimiter = newUserRateLimiter(rate.Limit(postRateLimitPerSecond), postRateLimitBurst)
+
+// 配信ごとのスローモード
+var slowModeLimiter = newSlowModeTracker()
+
+// tooManyRequestsError は429で返すエラー。errorResponseHandlerでRetry-Afterヘッダを付ける
+type tooManyRequestsError struct {
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *tooManyRequestsError) Error() string {
+	return e.message
+}
+
+// retryAfterSeconds はRetry-Afterに入れる秒数。切り上げて最低1秒にする
+func (e *tooManyRequestsError) retryAfterSeconds() int64 {
+	seconds := int64(math.Ceil(e.retryAfter.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// userRateLimiter はユーザごとのトークンバケット
+type userRateLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	limiters  map[int64]*userRateLimiterEntry
+	lastSweep time.Time
+}
+
+type userRateLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+func newUserRateLimiter(limit rate.Limit, burst int) *userRateLimiter {
+	return &userRateLimiter{
+		limit:    limit,
+		burst:    burst,
+		limiters: make(map[int64]*userRateLimiterEntry),
+	}
+}
+
+// reserve はトークンを1つ取り置く。足りなければ取らずに、次に使えるまでの時間を返す
+// 投稿が失敗したらcancelでトークンを戻す
+func (l *userRateLimiter) reserve(userID int64, now time.Time) (*rate.Reservation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	entry, ok := l.limiters[userID]
+	if !ok {
+		entry = &userRateLimiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[userID] = entry
+	}
+	entry.lastUsed = now
+
+	r := entry.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return nil, &tooManyRequestsError{
+			message:    "too many posts, please slow down",
+			retryAfter: delay,
+		}
+	}
+	return r, nil
+}
+
+// sweep はしばらく使われていないバケットを捨てる。l.muを取った状態で呼ぶ
+// 使われていない間にバケットは満タンになっているので、捨てても挙動は変わらない
+func (l *userRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimiterIdleTTL {
+		return
+	}
+	l.lastSweep = now
+	for userID, entry := range l.limiters {
+		if now.Sub(entry.lastUsed) > rateLimiterIdleTTL {
+			delete(l.limiters, userID)
+		}
+	}
+}
+
+func (l *userRateLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limiters = make(map[int64]*userRateLimiterEntry)
+}
+
+// slowModeTracker は配信ごと・ユーザごとの最後の投稿時刻を持つ
+type slowModeTracker struct {
+	mu        sync.Mutex
+	lastPosts map[slowModeKey]time.Time
+	// 判定を通ってまだ保存が終わっていない投稿
+	pending   map[slowModeKey]struct{}
+	lastSweep time.Time
+}
+
+type slowModeKey struct {
+	livestreamID int64
+	userID       int64
+}
+
+func newSlowModeTracker() *slowModeTracker {
+	return &slowModeTracker{
+		lastPosts: make(map[slowModeKey]time.Time),
+		pending:   make(map[slowModeKey]struct{}),
+	}
+}
+
+// reserve は前回の投稿からinterval経っていて、保存中の投稿もなければ投稿を受け付ける
+// 投稿時刻は保存が終わってからcommitで記録し、失敗したらcancelで受け付けを取り消す
+// 判定と受け付けを同じロックの中で行うので、同時に投稿されても1件しか通らない
+func (l *slowModeTracker) reserve(livestreamID int64, userID int64, interval time.Duration, now time.Time) (*slowModeReservation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	message := fmt.Sprintf("slow mode is enabled, you can post once every %d seconds", int64(interval.Seconds()))
+	key := slowModeKey{livestreamID: livestreamID, userID: userID}
+	if _, ok := l.pending[key]; ok {
+		return nil, &tooManyRequestsError{message: message, retryAfter: interval}
+	}
+	if last, ok := l.lastPosts[key]; ok {
+		if wait := last.Add(interval).Sub(now); wait > 0 {
+			return nil, &tooManyRequestsError{message: message, retryAfter: wait}
+		}
+	}
+	l.pending[key] = struct{}{}
+	return &slowModeReservation{tracker: l, key: key}, nil
+}
+
+// slowModeReservation はスローモードの判定を通った投稿。nilならスローモードの対象外
+type slowModeReservation struct {
+	tracker *slowModeTracker
+	key     slowModeKey
+}
+
+// commit は投稿の保存が終わった時刻を記録する
+func (r *slowModeReservation) commit(now time.Time) {
+	if r == nil {
+		return
+	}
+	r.tracker.mu.Lock()
+	defer r.tracker.mu.Unlock()
+	delete(r.tracker.pending, r.key)
+	r.tracker.lastPosts[r.key] = now
+}
+
+// cancel は投稿が失敗したので、記録せずに受け付けを取り消す
+func (r *slowModeReservation) cancel() {
+	if r == nil {
+		return
+	}
+	r.tracker.mu.Lock()
+	defer r.tracker.mu.Unlock()
+	delete(r.tracker.pending, r.key)
+}
+
+// sweep はスローモードの最大秒数より前の投稿時刻を捨てる。l.muを取った状態で呼ぶ
+func (l *slowModeTracker) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimiterIdleTTL {
+		return
+	}
+	l.lastSweep = now
+	for key, last := range l.lastPosts {
+		if now.Sub(last) > maxSlowModeSeconds*time.Second {
+			delete(l.lastPosts, key)
+		}
+	}
+}
+
+func (l *slowModeTracker) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastPosts = make(map[slowModeKey]time.Time)
+	l.pending = make(map[slowModeKey]struct{})
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -52,20 +53,25 @@ func getReactionsHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	query := "SELECT * FROM reactions WHERE livestream_id = ? ORDER BY created_at DESC"
-	if c.QueryParam("limit") != "" {
-		limit, err := strconv.Atoi(c.QueryParam("limit"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
-		}
-		query += fmt.Sprintf(" LIMIT %d", limit)
+	paging, err := parsePageParams(c)
+	if err != nil {
+		return err
 	}
+	cond, order, args := paging.keysetQuery("created_at", "id", true)
+	query := "SELECT * FROM reactions WHERE livestream_id = ?" + cond + order
 
 	reactionModels := []ReactionModel{}
-	if err := tx.SelectContext(ctx, &reactionModels, query, livestreamID); err != nil {
+	if err := tx.SelectContext(ctx, &reactionModels, query, append([]interface{}{livestreamID}, args...)...); err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "failed to get reactions")
 	}
 
+	var page pageInfo
+	if paging.Paginated {
+		reactionModels, page = paginate(paging, reactionModels, func(m ReactionModel) pageCursor {
+			return pageCursor{Key: m.CreatedAt, ID: m.ID}
+		})
+	}
+
 	reactions := make([]Reaction, len(reactionModels))
 	for i := range reactionModels {
 		reaction, err := fillReactionResponse(ctx, tx, reactionModels[i])
@@ -80,6 +86,9 @@ func getReactionsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	if paging.Paginated {
+		return c.JSON(http.StatusOK, newPage(reactions, page))
+	}
 	return c.JSON(http.StatusOK, reactions)
 }
 
@@ -105,40 +114,85 @@ func postReactionHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
+	reaction, err := insertReaction(ctx, userID, int64(livestreamID), req)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, reaction)
+}
+
+// insertReaction はリアクションを保存し、購読者へ配信する
+// HTTPとWebSocketの両方から使うので、エラーはecho.NewHTTPErrorで返す
+func insertReaction(ctx context.Context, userID int64, livestreamID int64, req *PostReactionRequest) (Reaction, error) {
+	rateLimit, err := postRateLimiter.reserve(userID, time.Now())
+	if err != nil {
+		return Reaction{}, err
+	}
+	// BANなどで投稿できなかったら、レート制限に数えない
+	posted := false
+	defer func() {
+		if !posted {
+			rateLimit.Cancel()
+		}
+	}()
+
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+		return Reaction{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
 	}
 	defer tx.Rollback()
 
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Reaction{}, echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return Reaction{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+
+	if err := verifyLivestreamLive(livestreamModel); err != nil {
+		return Reaction{}, err
+	}
+	if err := verifyNotBanned(ctx, tx, livestreamID, userID); err != nil {
+		return Reaction{}, err
+	}
+
 	reactionModel := ReactionModel{
-		UserID:       int64(userID),
-		LivestreamID: int64(livestreamID),
+		UserID:       userID,
+		LivestreamID: livestreamID,
 		EmojiName:    req.EmojiName,
 		CreatedAt:    time.Now().Unix(),
 	}
 
 	result, err := tx.NamedExecContext(ctx, "INSERT INTO reactions (user_id, livestream_id, emoji_name, created_at) VALUES (:user_id, :livestream_id, :emoji_name, :created_at)", reactionModel)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert reaction: "+err.Error())
+		return Reaction{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to insert reaction: "+err.Error())
 	}
 
 	reactionID, err := result.LastInsertId()
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted reaction id: "+err.Error())
+		return Reaction{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted reaction id: "+err.Error())
 	}
 	reactionModel.ID = reactionID
 
 	reaction, err := fillReactionResponse(ctx, tx, reactionModel)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill reaction: "+err.Error())
+		return Reaction{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to fill reaction: "+err.Error())
 	}
 
 	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		return Reaction{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
+	posted = true
 
-	return c.JSON(http.StatusCreated, reaction)
+	livestreamEvents.publish(livestreamID, livestreamEvent{
+		Type: livestreamEventReaction,
+		Data: reaction,
+	})
+
+	return reaction, nil
 }
 
 func fillReactionResponse(ctx context.Context, tx *sqlx.Tx, reactionModel ReactionModel) (Reaction, error) {
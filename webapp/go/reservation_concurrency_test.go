@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// テスト用の予約期間。初期データの予約期間と重ならない遠い未来に置く
+const (
+	reservationTestSlotSeconds = 3600
+	reservationTestSlotCount   = 4
+)
+
+type reservationTestEnv struct {
+	client  *http.Client
+	baseURL string
+	userID  int64
+	// 予約期間の先頭 (各テストで別の時間帯を使う)
+	startAt  int64
+	capacity int64
+}
+
+// setupReservationTest はMySQL (main.goと同じ環境変数で接続する) にテスト用のユーザ・予約期間・予約枠を作り、
+// ログイン済みのクライアントを返す
+// 接続先の環境変数が指定されていればMySQLにつながらないと失敗にし、指定がなくつながらなければスキップする
+func setupReservationTest(t *testing.T, startAt int64, capacity int64) *reservationTestEnv {
+	t.Helper()
+
+	db, err := connectDB(echo.New().Logger)
+	if err != nil {
+		if _, ok := os.LookupEnv("ISUCON13_MYSQL_DIALCONFIG_ADDRESS"); ok {
+			t.Fatalf("MySQL is not available: %v", err)
+		}
+		t.Skipf("MySQL is not available (set ISUCON13_MYSQL_DIALCONFIG_ADDRESS to require it): %v", err)
+	}
+	dbConn = db
+	t.Cleanup(func() { db.Close() })
+
+	endAt := startAt + reservationTestSlotSeconds*reservationTestSlotCount
+	cleanup := func() {
+		db.Exec("DELETE FROM reservation_slots WHERE start_at >= ? AND end_at <= ?", startAt, endAt)
+		db.Exec("DELETE FROM reservation_terms WHERE start_at = ? AND end_at = ?", startAt, endAt)
+		db.Exec("DELETE FROM livestreams WHERE start_at >= ? AND end_at <= ?", startAt, endAt)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	name := fmt.Sprintf("reservation-test-%d", time.Now().UnixNano())
+	rs, err := db.Exec("INSERT INTO users (name, display_name, password, description) VALUES (?, ?, '', '')", name, name)
+	if err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	userID, err := rs.LastInsertId()
+	if err != nil {
+		t.Fatalf("failed to get user id: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM themes WHERE user_id = ?", userID)
+		db.Exec("DELETE FROM users WHERE id = ?", userID)
+	})
+	if _, err := db.Exec("INSERT INTO themes (user_id, dark_mode) VALUES (?, false)", userID); err != nil {
+		t.Fatalf("failed to insert theme: %v", err)
+	}
+
+	if _, err := db.Exec("INSERT INTO reservation_terms (start_at, end_at, slot_seconds, capacity, created_at) VALUES (?, ?, ?, ?, ?)", startAt, endAt, reservationTestSlotSeconds, capacity, time.Now().Unix()); err != nil {
+		t.Fatalf("failed to insert reservation term: %v", err)
+	}
+	for s := startAt; s < endAt; s += reservationTestSlotSeconds {
+		if _, err := db.Exec("INSERT INTO reservation_slots (slot, start_at, end_at) VALUES (?, ?, ?)", capacity, s, s+reservationTestSlotSeconds); err != nil {
+			t.Fatalf("failed to insert reservation slot: %v", err)
+		}
+	}
+
+	e := echo.New()
+	e.Use(session.Middleware(sessions.NewCookieStore(secret)))
+	e.POST("/api/livestream/reservation", reserveLivestreamHandler)
+	e.PATCH("/api/livestream/:livestream_id/reservation", patchReservationHandler)
+	e.POST("/api/livestream/:livestream_id/cancel", cancelLivestreamHandler)
+	// loginHandlerのパスワード確認を省いて、セッションだけ発行する
+	e.POST("/test/login", func(c echo.Context) error {
+		sess, _ := session.Get(defaultSessionIDKey, c)
+		sess.Values[defaultSessionIDKey] = name
+		sess.Values[defaultUserIDKey] = userID
+		sess.Values[defaultUsernameKey] = name
+		sess.Values[defaultSessionExpiresKey] = time.Now().Add(time.Hour).Unix()
+		if err := sess.Save(c.Request(), c.Response()); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	})
+	e.HTTPErrorHandler = errorResponseHandler
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("failed to create cookie jar: %v", err)
+	}
+	env := &reservationTestEnv{
+		client:   &http.Client{Jar: jar},
+		baseURL:  server.URL,
+		userID:   userID,
+		startAt:  startAt,
+		capacity: capacity,
+	}
+	if status, body := env.do(t, http.MethodPost, "/test/login", nil); status != http.StatusOK {
+		t.Fatalf("failed to login: %d %s", status, body)
+	}
+	return env
+}
+
+func (env *reservationTestEnv) do(t *testing.T, method string, path string, body interface{}) (int, string) {
+	t.Helper()
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Errorf("failed to marshal request: %v", err)
+			return 0, ""
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, env.baseURL+path, r)
+	if err != nil {
+		t.Errorf("failed to create request: %v", err)
+		return 0, ""
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	res, err := env.client.Do(req)
+	if err != nil {
+		t.Errorf("failed to send request: %v", err)
+		return 0, ""
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(b)
+}
+
+func (env *reservationTestEnv) reserve(t *testing.T, startAt int64, endAt int64) (int, string) {
+	t.Helper()
+	return env.do(t, http.MethodPost, "/api/livestream/reservation", ReserveLivestreamRequest{
+		Tags:         []int64{},
+		Title:        "reservation test",
+		PlaylistUrl:  "https://media.example.com/playlist.m3u8",
+		ThumbnailUrl: "https://media.example.com/thumbnail.webp",
+		StartAt:      startAt,
+		EndAt:        endAt,
+	})
+}
+
+func (env *reservationTestEnv) slotStart(i int) int64 {
+	return env.startAt + int64(i)*reservationTestSlotSeconds
+}
+
+// watchMinSlot は止めるまで予約枠の最小値を見張り、見えた最小値を返す
+func (env *reservationTestEnv) watchMinSlot(t *testing.T) func() int64 {
+	t.Helper()
+
+	done := make(chan struct{})
+	result := make(chan int64)
+	go func() {
+		minSlot := env.capacity
+		for {
+			var slot int64
+			if err := dbConn.Get(&slot, "SELECT MIN(slot) FROM reservation_slots WHERE start_at >= ? AND end_at <= ?", env.startAt, env.slotStart(reservationTestSlotCount)); err == nil {
+				minSlot = min(minSlot, slot)
+			}
+			select {
+			case <-done:
+				result <- minSlot
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	return func() int64 {
+		close(done)
+		return <-result
+	}
+}
+
+// checkSlotAccounting は各予約枠の残りが、容量からその枠にかかる (中止されていない) 配信数を引いた値と一致するか調べる
+func (env *reservationTestEnv) checkSlotAccounting(t *testing.T) {
+	t.Helper()
+
+	var slots []ReservationSlotModel
+	if err := dbConn.Select(&slots, "SELECT * FROM reservation_slots WHERE start_at >= ? AND end_at <= ? ORDER BY start_at", env.startAt, env.slotStart(reservationTestSlotCount)); err != nil {
+		t.Fatalf("failed to get reservation slots: %v", err)
+	}
+	if len(slots) != reservationTestSlotCount {
+		t.Fatalf("expected %d reservation slots, got %d", reservationTestSlotCount, len(slots))
+	}
+	for _, slot := range slots {
+		var reserved int64
+		if err := dbConn.Get(&reserved, "SELECT COUNT(*) FROM livestreams WHERE user_id = ? AND start_at <= ? AND end_at >= ? AND status <> ?", env.userID, slot.StartAt, slot.EndAt, livestreamStatusCancelled); err != nil {
+			t.Fatalf("failed to count livestreams: %v", err)
+		}
+		if slot.Slot < 0 {
+			t.Errorf("slot %d ~ %d went below 0: %d", slot.StartAt, slot.EndAt, slot.Slot)
+		}
+		if slot.Slot != env.capacity-reserved {
+			t.Errorf("slot %d ~ %d has %d remaining, but %d of %d are reserved", slot.StartAt, slot.EndAt, slot.Slot, reserved, env.capacity)
+		}
+	}
+}
+
+// 並列な予約が同じ行を取り合ったときのデッドロックはロールバックされるだけなので許容する
+func isDeadlockResponse(status int, body string) bool {
+	return status == http.StatusInternalServerError && strings.Contains(body, "Deadlock")
+}
+
+func TestReserveLivestreamNoOverbooking(t *testing.T) {
+	const (
+		capacity = 5
+		parallel = 40
+	)
+	// 2100-01-01 00:00:00 UTC
+	env := setupReservationTest(t, 4102444800, capacity)
+
+	stop := env.watchMinSlot(t)
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+	)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, body := env.reserve(t, env.slotStart(0), env.slotStart(1))
+			switch status {
+			case http.StatusCreated:
+				mu.Lock()
+				created++
+				mu.Unlock()
+			case http.StatusBadRequest:
+			default:
+				t.Errorf("unexpected response: %d %s", status, body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if minSlot := stop(); minSlot < 0 {
+		t.Errorf("slot went below 0: %d", minSlot)
+	}
+	if created != capacity {
+		t.Errorf("expected %d reservations to succeed, got %d", capacity, created)
+	}
+	env.checkSlotAccounting(t)
+}
+
+func TestReserveLivestreamOverlappingRangesNoOverbooking(t *testing.T) {
+	const (
+		capacity = 3
+		parallel = 30
+	)
+	// 2100-01-02 00:00:00 UTC
+	env := setupReservationTest(t, 4102531200, capacity)
+
+	stop := env.watchMinSlot(t)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// 2枠ずつずらして重ねる
+			first := i % (reservationTestSlotCount - 1)
+			status, body := env.reserve(t, env.slotStart(first), env.slotStart(first+2))
+			if status != http.StatusCreated && status != http.StatusBadRequest && !isDeadlockResponse(status, body) {
+				t.Errorf("unexpected response: %d %s", status, body)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if minSlot := stop(); minSlot < 0 {
+		t.Errorf("slot went below 0: %d", minSlot)
+	}
+	env.checkSlotAccounting(t)
+}
+
+func TestRescheduleAndCancelReservationConcurrently(t *testing.T) {
+	const capacity = 3
+	// 2100-01-03 00:00:00 UTC
+	env := setupReservationTest(t, 4102617600, capacity)
+
+	// 先頭の枠を埋めてから、時間変更と中止を同時に送る
+	livestreamIDs := make([]int64, 0, capacity)
+	for i := 0; i < capacity; i++ {
+		status, body := env.reserve(t, env.slotStart(0), env.slotStart(1))
+		if status != http.StatusCreated {
+			t.Fatalf("failed to reserve: %d %s", status, body)
+		}
+		var livestream Livestream
+		if err := json.Unmarshal([]byte(body), &livestream); err != nil {
+			t.Fatalf("failed to decode livestream: %v", err)
+		}
+		livestreamIDs = append(livestreamIDs, livestream.ID)
+	}
+
+	stop := env.watchMinSlot(t)
+	var wg sync.WaitGroup
+	for i, livestreamID := range livestreamIDs {
+		path := "/api/livestream/" + strconv.FormatInt(livestreamID, 10)
+		target := 1 + i%(reservationTestSlotCount-1)
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			status, body := env.do(t, http.MethodPatch, path+"/reservation", RescheduleReservationRequest{
+				StartAt: env.slotStart(target),
+				EndAt:   env.slotStart(target + 1),
+			})
+			if status != http.StatusOK && status != http.StatusConflict && status != http.StatusBadRequest && !isDeadlockResponse(status, body) {
+				t.Errorf("unexpected reschedule response: %d %s", status, body)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			status, body := env.do(t, http.MethodPost, path+"/cancel", nil)
+			if status != http.StatusOK && status != http.StatusConflict && !isDeadlockResponse(status, body) {
+				t.Errorf("unexpected cancel response: %d %s", status, body)
+			}
+		}()
+	}
+	// 空いた枠と移動先の枠を取り合う新しい予約
+	for i := 0; i < capacity*2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slot := i % reservationTestSlotCount
+			status, body := env.reserve(t, env.slotStart(slot), env.slotStart(slot+1))
+			if status != http.StatusCreated && status != http.StatusBadRequest && !isDeadlockResponse(status, body) {
+				t.Errorf("unexpected response: %d %s", status, body)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if minSlot := stop(); minSlot < 0 {
+		t.Errorf("slot went below 0: %d", minSlot)
+	}
+	env.checkSlotAccounting(t)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+type RescheduleReservationRequest struct {
+	StartAt int64 `json:"start_at"`
+	EndAt   int64 `json:"end_at"`
+}
+
+type ReservationAvailability struct {
+	Slots []ReservationSlotAvailability `json:"slots"`
+	// 指定した時刻から連続して予約できる最長の区間。予約できなければnull
+	LongestWindow *ReservationWindow `json:"longest_window"`
+}
+
+type ReservationSlotAvailability struct {
+	StartAt int64 `json:"start_at"`
+	EndAt   int64 `json:"end_at"`
+	// 残りの予約可能数
+	Remaining int64 `json:"remaining"`
+}
+
+type ReservationWindow struct {
+	StartAt int64 `json:"start_at"`
+	EndAt   int64 `json:"end_at"`
+}
+
+// 予約状況の取得期間の上限 (約1年)
+const maxReservationAvailabilitySeconds = 366 * 24 * 60 * 60
+
+// 予約状況取得API
+// GET /api/reservation/availability?from=&to=&start_at=
+// start_atを省略したら、fromから連続して予約できる区間を返す
+func getReservationAvailabilityHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	from, err := strconv.ParseInt(c.QueryParam("from"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "from query parameter must be integer")
+	}
+	to, err := strconv.ParseInt(c.QueryParam("to"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "to query parameter must be integer")
+	}
+	if from >= to {
+		return echo.NewHTTPError(http.StatusBadRequest, "from must be before to")
+	}
+	if to-from > maxReservationAvailabilitySeconds {
+		return echo.NewHTTPError(http.StatusBadRequest, "range between from and to is too long")
+	}
+	windowStartAt := from
+	if v := c.QueryParam("start_at"); v != "" {
+		windowStartAt, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "start_at query parameter must be integer")
+		}
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var slotModels []*ReservationSlotModel
+	if err := tx.SelectContext(ctx, &slotModels, "SELECT * FROM reservation_slots WHERE start_at >= ? AND end_at <= ? ORDER BY start_at", from, to); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+	}
+	slots := make([]ReservationSlotAvailability, len(slotModels))
+	for i, slotModel := range slotModels {
+		slots[i] = ReservationSlotAvailability{
+			StartAt:   slotModel.StartAt,
+			EndAt:     slotModel.EndAt,
+			Remaining: slotModel.Slot,
+		}
+	}
+
+	window, err := longestReservationWindow(ctx, tx, windowStartAt)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation window: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &ReservationAvailability{
+		Slots:         slots,
+		LongestWindow: window,
+	})
+}
+
+// longestReservationWindow はstartAtから始まり、空きのある予約枠が途切れずに続く区間を返す
+// 予約期間をまたぐ予約はできないので、startAtを含む予約期間の終わりまでで打ち切る
+func longestReservationWindow(ctx context.Context, tx *sqlx.Tx, startAt int64) (*ReservationWindow, error) {
+	var termModel ReservationTermModel
+	if err := tx.GetContext(ctx, &termModel, "SELECT * FROM reservation_terms WHERE start_at <= ? AND end_at > ?", startAt, startAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var slotModels []*ReservationSlotModel
+	if err := tx.SelectContext(ctx, &slotModels, "SELECT * FROM reservation_slots WHERE start_at >= ? AND end_at <= ? ORDER BY start_at", startAt, termModel.EndAt); err != nil {
+		return nil, err
+	}
+
+	endAt := startAt
+	for _, slotModel := range slotModels {
+		if slotModel.StartAt != endAt || slotModel.Slot < 1 {
+			break
+		}
+		endAt = slotModel.EndAt
+	}
+	if endAt == startAt {
+		return nil, nil
+	}
+	return &ReservationWindow{StartAt: startAt, EndAt: endAt}, nil
+}
+
+// (配信者向け)予約取り消しAPI。配信を中止し、確保していた予約枠を返す
+// DELETE /api/livestream/:livestream_id/reservation
+func deleteReservationHandler(c echo.Context) error {
+	return transitLivestreamStatus(c, livestreamStatusScheduled, livestreamStatusCancelled)
+}
+
+// (配信者向け)予約時間変更API
+// PATCH /api/livestream/:livestream_id/reservation
+func patchReservationHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var req *RescheduleReservationRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	termModel, err := verifyReservationTerm(ctx, tx, req.StartAt, req.EndAt)
+	if err != nil {
+		return err
+	}
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ? FOR UPDATE", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+	if livestreamModel.UserID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "can't manage other streamer's livestream")
+	}
+	if status := effectiveLivestreamStatus(livestreamModel, time.Now().Unix()); status != livestreamStatusScheduled {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("livestream can't be rescheduled while it is %s", status))
+	}
+
+	// 元の枠を返してから新しい枠を確保する。重なる時間帯への変更でも自分の枠とは競合しない
+	// 確保できなければロールバックされ、元の枠のまま残る
+	if err := releaseReservationSlots(ctx, tx, livestreamModel.StartAt, livestreamModel.EndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to release reservation slots: "+err.Error())
+	}
+	if err := claimReservationSlots(ctx, tx, termModel, req.StartAt, req.EndAt); err != nil {
+		return err
+	}
+	// 元の枠に空きができていれば、キャンセル待ちを繰り上げる
+	if err := promoteReservationWaitlist(ctx, tx, livestreamModel.StartAt, livestreamModel.EndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to promote reservation waitlist: "+err.Error())
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE livestreams SET start_at = ?, end_at = ?, version = version + 1 WHERE id = ?", req.StartAt, req.EndAt, livestreamModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livestream: "+err.Error())
+	}
+	livestreamModel.StartAt = req.StartAt
+	livestreamModel.EndAt = req.EndAt
+	livestreamModel.Version++
+
+	livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	setLivestreamETag(c, livestreamModel)
+	return c.JSON(http.StatusOK, livestream)
+}
+
+// verifyReservationTerm は予約区間がかかっている予約期間を取得し、予約枠の区切りに揃っているかを調べる
+func verifyReservationTerm(ctx context.Context, tx *sqlx.Tx, startAt int64, endAt int64) (ReservationTermModel, error) {
+	var termModel ReservationTermModel
+	if err := tx.GetContext(ctx, &termModel, "SELECT * FROM reservation_terms WHERE start_at < ? AND end_at > ? ORDER BY start_at LIMIT 1", endAt, startAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ReservationTermModel{}, echo.NewHTTPError(http.StatusBadRequest, "bad reservation time range")
+		}
+		return ReservationTermModel{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation term: "+err.Error())
+	}
+	if startAt >= endAt || (startAt-termModel.StartAt)%termModel.SlotSeconds != 0 || (endAt-termModel.StartAt)%termModel.SlotSeconds != 0 {
+		return ReservationTermModel{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("reservation time range must be aligned to %d seconds slots", termModel.SlotSeconds))
+	}
+	return termModel, nil
+}
+
+// claimReservationSlots は予約区間の予約枠をまとめて確保する。1つでも空きがなければ何も確保せずエラーにする
+// NOTE: 並列な予約のoverbooking防止に、予約枠をFOR UPDATEでロックしてから条件付きUPDATEで減らす
+func claimReservationSlots(ctx context.Context, tx *sqlx.Tx, termModel ReservationTermModel, startAt int64, endAt int64) error {
+	slots, err := lockReservationSlots(ctx, tx, startAt, endAt)
+	if err != nil {
+		return err
+	}
+
+	claimed, err := decrementReservationSlots(ctx, tx, startAt, endAt)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation_slot: "+err.Error())
+	}
+	if claimed != int64(len(slots)) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("予約期間 %d ~ %dに対して、予約区間 %d ~ %dが予約できません", termModel.StartAt, termModel.EndAt, startAt, endAt))
+	}
+	return nil
+}
+
+// lockReservationSlots は予約区間の予約枠をロックして取得する
+// 予約区間が予約枠の区切りで隙間なく埋まっていなければエラーにする
+func lockReservationSlots(ctx context.Context, tx *sqlx.Tx, startAt int64, endAt int64) ([]*ReservationSlotModel, error) {
+	if startAt >= endAt {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "start_at must be before end_at")
+	}
+
+	slots, covered, err := lockReservationSlotsInRange(ctx, tx, startAt, endAt)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+	}
+	if !covered {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("予約区間 %d ~ %dに予約枠のない時間があります", startAt, endAt))
+	}
+	return slots, nil
+}
+
+// lockReservationSlotsInRange は予約区間に含まれる予約枠をロックして取得する
+// 予約区間が予約枠の区切りで隙間なく埋まっていなければcoveredはfalseになる
+func lockReservationSlotsInRange(ctx context.Context, tx *sqlx.Tx, startAt int64, endAt int64) ([]*ReservationSlotModel, bool, error) {
+	var slots []*ReservationSlotModel
+	if err := tx.SelectContext(ctx, &slots, "SELECT * FROM reservation_slots WHERE start_at >= ? AND end_at <= ? ORDER BY start_at FOR UPDATE", startAt, endAt); err != nil {
+		return nil, false, err
+	}
+
+	next := startAt
+	for _, slot := range slots {
+		if slot.StartAt != next {
+			break
+		}
+		next = slot.EndAt
+	}
+	return slots, startAt < endAt && next == endAt, nil
+}
+
+// decrementReservationSlots は予約区間の予約枠を1つのUPDATEでまとめて減らし、減らした予約枠の数を返す
+// 空きのない予約枠が1つでもあれば、どの予約枠も減らさない
+func decrementReservationSlots(ctx context.Context, tx *sqlx.Tx, startAt int64, endAt int64) (int64, error) {
+	rs, err := tx.ExecContext(ctx, `UPDATE reservation_slots
+		JOIN (SELECT COUNT(*) AS full_slots FROM reservation_slots WHERE start_at >= ? AND end_at <= ? AND slot < 1) AS f ON f.full_slots = 0
+		SET reservation_slots.slot = reservation_slots.slot - 1
+		WHERE reservation_slots.start_at >= ? AND reservation_slots.end_at <= ?`, startAt, endAt, startAt, endAt)
+	if err != nil {
+		return 0, err
+	}
+	return rs.RowsAffected()
+}
+
+// reservationSlotsAvailable は予約区間の予約枠をロックし、すべてに空きがあるかを調べる
+func reservationSlotsAvailable(ctx context.Context, tx *sqlx.Tx, startAt int64, endAt int64) (bool, error) {
+	slots, err := lockReservationSlots(ctx, tx, startAt, endAt)
+	if err != nil {
+		return false, err
+	}
+	for _, slot := range slots {
+		if slot.Slot < 1 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// releaseReservationSlots はclaimReservationSlotsで確保した予約枠を返す
+// UPDATEで予約枠の行ロックを取るので、並列な予約とは直列になる
+func releaseReservationSlots(ctx context.Context, tx *sqlx.Tx, startAt int64, endAt int64) error {
+	_, err := tx.ExecContext(ctx, "UPDATE reservation_slots SET slot = slot + 1 WHERE start_at >= ? AND end_at <= ?", startAt, endAt)
+	return err
+}
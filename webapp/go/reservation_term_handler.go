@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// サービス運営者のユーザ名。登録APIでは予約済みの名前なので、初期データ (initial_users.sql) で作る
+const adminUserName = "pipe"
+
+const (
+	// 予約枠の長さの最小秒数
+	minReservationSlotSeconds = 60
+	// 1つの予約期間に作れる予約枠の最大数 (1年分を15分ごとに区切っても収まる)
+	maxReservationTermSlots = 50000
+	// 予約枠を一度のINSERTで作る件数。プレースホルダの数がMySQLの上限を超えないようにする
+	reservationSlotInsertBatchSize = 1000
+)
+
+type ReservationTermModel struct {
+	ID          int64 `db:"id" json:"id"`
+	StartAt     int64 `db:"start_at" json:"start_at"`
+	EndAt       int64 `db:"end_at" json:"end_at"`
+	SlotSeconds int64 `db:"slot_seconds" json:"slot_seconds"`
+	Capacity    int64 `db:"capacity" json:"capacity"`
+	CreatedAt   int64 `db:"created_at" json:"created_at"`
+}
+
+type PostReservationTermRequest struct {
+	StartAt     int64 `json:"start_at"`
+	EndAt       int64 `json:"end_at"`
+	SlotSeconds int64 `json:"slot_seconds"`
+	Capacity    int64 `json:"capacity"`
+}
+
+type GenerateReservationSlotsResponse struct {
+	// 新たに作った予約枠の数。既にある予約枠はそのまま残す
+	CreatedCount int64 `json:"created_count"`
+}
+
+type AdjustReservationSlotsRequest struct {
+	StartAt int64 `json:"start_at"`
+	EndAt   int64 `json:"end_at"`
+	// 空き枠の増減
+	Delta int64 `json:"delta"`
+}
+
+// (運営向け)予約期間一覧取得API
+// GET /api/admin/reservation_term
+func getReservationTermsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tx, err := beginAdminTx(c)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	termModels := []ReservationTermModel{}
+	if err := tx.SelectContext(ctx, &termModels, "SELECT * FROM reservation_terms ORDER BY start_at"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation terms: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, termModels)
+}
+
+// (運営向け)予約期間登録API
+// POST /api/admin/reservation_term
+func postReservationTermHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	var req *PostReservationTermRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.StartAt >= req.EndAt {
+		return echo.NewHTTPError(http.StatusBadRequest, "start_at must be before end_at")
+	}
+	if req.SlotSeconds < minReservationSlotSeconds || (req.EndAt-req.StartAt)%req.SlotSeconds != 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("slot_seconds must be at least %d and divide the term", minReservationSlotSeconds))
+	}
+	if (req.EndAt-req.StartAt)/req.SlotSeconds > maxReservationTermSlots {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("a reservation term can have at most %d slots", maxReservationTermSlots))
+	}
+	if req.Capacity < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "capacity must not be negative")
+	}
+
+	tx, err := beginAdminTx(c)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 予約区間から予約期間を一意に決めるため、期間は重ならないようにする
+	var overlapped int64
+	if err := tx.GetContext(ctx, &overlapped, "SELECT COUNT(*) FROM reservation_terms WHERE start_at < ? AND end_at > ? FOR UPDATE", req.EndAt, req.StartAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation terms: "+err.Error())
+	}
+	if overlapped > 0 {
+		return echo.NewHTTPError(http.StatusConflict, "reservation term overlaps with an existing term")
+	}
+
+	termModel := ReservationTermModel{
+		StartAt:     req.StartAt,
+		EndAt:       req.EndAt,
+		SlotSeconds: req.SlotSeconds,
+		Capacity:    req.Capacity,
+		CreatedAt:   time.Now().Unix(),
+	}
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO reservation_terms (start_at, end_at, slot_seconds, capacity, created_at) VALUES (:start_at, :end_at, :slot_seconds, :capacity, :created_at)", termModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert reservation term: "+err.Error())
+	}
+	termID, err := rs.LastInsertId()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted reservation term id: "+err.Error())
+	}
+	termModel.ID = termID
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, termModel)
+}
+
+// (運営向け)予約期間削除API。作成済みの予約枠と予約は残るが、新たな予約はできなくなる
+// DELETE /api/admin/reservation_term/:term_id
+func deleteReservationTermHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	termID, err := strconv.Atoi(c.Param("term_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "term_id in path must be integer")
+	}
+
+	tx, err := beginAdminTx(c)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rs, err := tx.ExecContext(ctx, "DELETE FROM reservation_terms WHERE id = ?", termID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete reservation term: "+err.Error())
+	}
+	if n, err := rs.RowsAffected(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get affected rows: "+err.Error())
+	} else if n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "reservation term not found")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// (運営向け)予約期間の予約枠を一括で作るAPI
+// POST /api/admin/reservation_term/:term_id/slots
+func generateReservationSlotsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	termID, err := strconv.Atoi(c.Param("term_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "term_id in path must be integer")
+	}
+
+	tx, err := beginAdminTx(c)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var termModel ReservationTermModel
+	if err := tx.GetContext(ctx, &termModel, "SELECT * FROM reservation_terms WHERE id = ?", termID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "reservation term not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation term: "+err.Error())
+	}
+
+	// 予約と競合しないよう、既にある予約枠をロックしてから足りない枠だけ作る
+	var existingStartAts []int64
+	if err := tx.SelectContext(ctx, &existingStartAts, "SELECT start_at FROM reservation_slots WHERE start_at >= ? AND end_at <= ? FOR UPDATE", termModel.StartAt, termModel.EndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+	}
+	existing := make(map[int64]struct{}, len(existingStartAts))
+	for _, startAt := range existingStartAts {
+		existing[startAt] = struct{}{}
+	}
+
+	var slotModels []ReservationSlotModel
+	for startAt := termModel.StartAt; startAt < termModel.EndAt; startAt += termModel.SlotSeconds {
+		if _, ok := existing[startAt]; ok {
+			continue
+		}
+		slotModels = append(slotModels, ReservationSlotModel{
+			Slot:    termModel.Capacity,
+			StartAt: startAt,
+			EndAt:   startAt + termModel.SlotSeconds,
+		})
+	}
+	for i := 0; i < len(slotModels); i += reservationSlotInsertBatchSize {
+		batch := slotModels[i:min(i+reservationSlotInsertBatchSize, len(slotModels))]
+		if _, err := tx.NamedExecContext(ctx, "INSERT INTO reservation_slots (slot, start_at, end_at) VALUES (:slot, :start_at, :end_at)", batch); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert reservation_slots: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, &GenerateReservationSlotsResponse{
+		CreatedCount: int64(len(slotModels)),
+	})
+}
+
+// (運営向け)予約枠の空き数を増減するAPI
+// PATCH /api/admin/reservation_slots
+func adjustReservationSlotsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	var req *AdjustReservationSlotsRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.StartAt >= req.EndAt {
+		return echo.NewHTTPError(http.StatusBadRequest, "start_at must be before end_at")
+	}
+
+	tx, err := beginAdminTx(c)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// NOTE: 並列な予約と競合しないようにFOR UPDATEが必要
+	var slotModels []*ReservationSlotModel
+	if err := tx.SelectContext(ctx, &slotModels, "SELECT * FROM reservation_slots WHERE start_at >= ? AND end_at <= ? ORDER BY start_at FOR UPDATE", req.StartAt, req.EndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+	}
+	if len(slotModels) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "reservation slots not found")
+	}
+	// 既に予約されている分は減らせない
+	for _, slotModel := range slotModels {
+		if slotModel.Slot+req.Delta < 0 {
+			return echo.NewHTTPError(http.StatusConflict, "slots can't be reduced below the number of reservations")
+		}
+		slotModel.Slot += req.Delta
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE reservation_slots SET slot = slot + ? WHERE start_at >= ? AND end_at <= ?", req.Delta, req.StartAt, req.EndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation_slots: "+err.Error())
+	}
+	if req.Delta > 0 {
+		if err := promoteReservationWaitlist(ctx, tx, req.StartAt, req.EndAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to promote reservation waitlist: "+err.Error())
+		}
+		// 繰り上げで使われた分を反映する
+		slotModels = nil
+		if err := tx.SelectContext(ctx, &slotModels, "SELECT * FROM reservation_slots WHERE start_at >= ? AND end_at <= ? ORDER BY start_at", req.StartAt, req.EndAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, slotModels)
+}
+
+// beginAdminTx はログイン中のユーザが運営者であることを確かめて、トランザクションを始める
+func beginAdminTx(c echo.Context) (*sqlx.Tx, error) {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return nil, err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	if err := verifyAdmin(ctx, tx, userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+func verifyAdmin(ctx context.Context, tx *sqlx.Tx, userID int64) error {
+	var name string
+	if err := tx.GetContext(ctx, &name, "SELECT name FROM users WHERE id = ?", userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+	if name != adminUserName {
+		return echo.NewHTTPError(http.StatusForbidden, "only the service admin can manage reservations")
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// キャンセル待ちの状態
+const (
+	waitlistStatusWaiting = "waiting"
+	// 空きができて配信を予約した
+	waitlistStatusPromoted = "promoted"
+	// 配信者が取り下げた
+	waitlistStatusCancelled = "cancelled"
+	// 開始時刻を過ぎたか、予約枠のない時間を含むようになって繰り上げられなくなった
+	waitlistStatusExpired = "expired"
+)
+
+type ReservationWaitlistEntryModel struct {
+	ID     int64 `db:"id"`
+	UserID int64 `db:"user_id"`
+	// 予約時のリクエスト (ReserveLivestreamRequestのJSON)
+	Request      string        `db:"request"`
+	StartAt      int64         `db:"start_at"`
+	EndAt        int64         `db:"end_at"`
+	Status       string        `db:"status"`
+	LivestreamID sql.NullInt64 `db:"livestream_id"`
+	CreatedAt    int64         `db:"created_at"`
+	PromotedAt   sql.NullInt64 `db:"promoted_at"`
+}
+
+type ReservationWaitlistEntry struct {
+	ID        int64  `json:"id"`
+	Title     string `json:"title"`
+	StartAt   int64  `json:"start_at"`
+	EndAt     int64  `json:"end_at"`
+	Status    string `json:"status"`
+	CreatedAt int64  `json:"created_at"`
+	// 予約できた場合のみ
+	LivestreamID *int64 `json:"livestream_id,omitempty"`
+	PromotedAt   *int64 `json:"promoted_at,omitempty"`
+}
+
+// (配信者向け)自分のキャンセル待ち一覧取得API
+// GET /api/user/me/reservation/waitlist
+func getReservationWaitlistHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var entryModels []ReservationWaitlistEntryModel
+	if err := tx.SelectContext(ctx, &entryModels, "SELECT * FROM reservation_waitlist WHERE user_id = ? ORDER BY created_at DESC, id DESC", userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation waitlist: "+err.Error())
+	}
+
+	entries := make([]ReservationWaitlistEntry, len(entryModels))
+	for i := range entryModels {
+		entry, err := fillReservationWaitlistEntryResponse(entryModels[i])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill reservation waitlist entry: "+err.Error())
+		}
+		entries[i] = entry
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
+
+// (配信者向け)キャンセル待ち取り下げAPI
+// DELETE /api/user/me/reservation/waitlist/:entry_id
+func deleteReservationWaitlistEntryHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	entryID, err := strconv.Atoi(c.Param("entry_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "entry_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var entryModel ReservationWaitlistEntryModel
+	if err := tx.GetContext(ctx, &entryModel, "SELECT * FROM reservation_waitlist WHERE id = ? AND user_id = ? FOR UPDATE", entryID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "reservation waitlist entry not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation waitlist entry: "+err.Error())
+	}
+	if entryModel.Status != waitlistStatusWaiting {
+		return echo.NewHTTPError(http.StatusConflict, "reservation waitlist entry is already "+entryModel.Status)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE reservation_waitlist SET status = ? WHERE id = ?", waitlistStatusCancelled, entryModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation waitlist entry: "+err.Error())
+	}
+	entryModel.Status = waitlistStatusCancelled
+
+	entry, err := fillReservationWaitlistEntryResponse(entryModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill reservation waitlist entry: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, entry)
+}
+
+// joinReservationWaitlist は予約枠が埋まっていた予約をキャンセル待ちに登録する
+func joinReservationWaitlist(ctx context.Context, tx *sqlx.Tx, userID int64, req *ReserveLivestreamRequest) (ReservationWaitlistEntry, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return ReservationWaitlistEntry{}, err
+	}
+
+	entryModel := ReservationWaitlistEntryModel{
+		UserID:    userID,
+		Request:   string(b),
+		StartAt:   req.StartAt,
+		EndAt:     req.EndAt,
+		Status:    waitlistStatusWaiting,
+		CreatedAt: time.Now().Unix(),
+	}
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO reservation_waitlist (user_id, request, start_at, end_at, status, created_at) VALUES (:user_id, :request, :start_at, :end_at, :status, :created_at)", entryModel)
+	if err != nil {
+		return ReservationWaitlistEntry{}, err
+	}
+	entryID, err := rs.LastInsertId()
+	if err != nil {
+		return ReservationWaitlistEntry{}, err
+	}
+	entryModel.ID = entryID
+
+	return fillReservationWaitlistEntryResponse(entryModel)
+}
+
+// promoteReservationWaitlist は予約枠が空いた区間に重なるキャンセル待ちを、登録順に予約できるものから配信として予約する
+// 予約枠を返したのと同じトランザクションで呼ぶ。繰り上げられなくなったキャンセル待ちは期限切れにする
+func promoteReservationWaitlist(ctx context.Context, tx *sqlx.Tx, startAt int64, endAt int64) error {
+	var entryModels []ReservationWaitlistEntryModel
+	if err := tx.SelectContext(ctx, &entryModels, "SELECT * FROM reservation_waitlist WHERE status = ? AND start_at < ? AND end_at > ? ORDER BY created_at, id FOR UPDATE", waitlistStatusWaiting, endAt, startAt); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, entryModel := range entryModels {
+		if entryModel.StartAt <= now {
+			if err := expireReservationWaitlistEntry(ctx, tx, entryModel.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// 予約枠のない時間を含むキャンセル待ちで、キャンセルや予約の変更全体を失敗させない
+		slots, covered, err := lockReservationSlotsInRange(ctx, tx, entryModel.StartAt, entryModel.EndAt)
+		if err != nil {
+			return err
+		}
+		if !covered {
+			if err := expireReservationWaitlistEntry(ctx, tx, entryModel.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		if slices.ContainsFunc(slots, func(slot *ReservationSlotModel) bool { return slot.Slot < 1 }) {
+			continue
+		}
+
+		var req ReserveLivestreamRequest
+		if err := json.Unmarshal([]byte(entryModel.Request), &req); err != nil {
+			return err
+		}
+		// ロック済みで空きも確認しているので、減らせなければ予約枠の状態がおかしい
+		claimed, err := decrementReservationSlots(ctx, tx, entryModel.StartAt, entryModel.EndAt)
+		if err != nil {
+			return err
+		}
+		if claimed == 0 {
+			return errors.New("failed to claim reservation slots for waitlist entry")
+		}
+		livestreamModel, err := insertReservedLivestream(ctx, tx, entryModel.UserID, &req)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE reservation_waitlist SET status = ?, livestream_id = ?, promoted_at = ? WHERE id = ?", waitlistStatusPromoted, livestreamModel.ID, now, entryModel.ID); err != nil {
+			return err
+		}
+		if err := notifyUser(ctx, tx, entryModel.UserID, notificationTypeReservationPromoted, map[string]interface{}{
+			"waitlist_entry_id": entryModel.ID,
+			"livestream_id":     livestreamModel.ID,
+			"start_at":          livestreamModel.StartAt,
+			"end_at":            livestreamModel.EndAt,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expireReservationWaitlistEntry(ctx context.Context, tx *sqlx.Tx, entryID int64) error {
+	_, err := tx.ExecContext(ctx, "UPDATE reservation_waitlist SET status = ? WHERE id = ?", waitlistStatusExpired, entryID)
+	return err
+}
+
+func fillReservationWaitlistEntryResponse(entryModel ReservationWaitlistEntryModel) (ReservationWaitlistEntry, error) {
+	var req ReserveLivestreamRequest
+	if err := json.Unmarshal([]byte(entryModel.Request), &req); err != nil {
+		return ReservationWaitlistEntry{}, err
+	}
+
+	entry := ReservationWaitlistEntry{
+		ID:        entryModel.ID,
+		Title:     req.Title,
+		StartAt:   entryModel.StartAt,
+		EndAt:     entryModel.EndAt,
+		Status:    entryModel.Status,
+		CreatedAt: entryModel.CreatedAt,
+	}
+	if entryModel.LivestreamID.Valid {
+		entry.LivestreamID = &entryModel.LivestreamID.Int64
+	}
+	if entryModel.PromotedAt.Valid {
+		entry.PromotedAt = &entryModel.PromotedAt.Int64
+	}
+	return entry, nil
+}
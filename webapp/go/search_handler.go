@@ -0,0 +1,287 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// スニペットの最大文字数 (前後の省略記号は含まない)
+	maxSearchSnippetLength = 100
+	// MySQLのngram_token_size (デフォルト値)。これより短い語はFULLTEXTインデックスでは引けない
+	ngramTokenSize = 2
+)
+
+// 検索対象
+const (
+	searchTypeLivestream  = "livestream"
+	searchTypeLivecomment = "livecomment"
+)
+
+type SearchResponse struct {
+	Livestreams  []LivestreamSearchHit  `json:"livestreams"`
+	Livecomments []LivecommentSearchHit `json:"livecomments"`
+}
+
+type LivestreamSearchHit struct {
+	Livestream  Livestream    `json:"livestream"`
+	Score       float64       `json:"score"`
+	Title       SearchSnippet `json:"title"`
+	Description SearchSnippet `json:"description"`
+}
+
+type LivecommentSearchHit struct {
+	Livecomment Livecomment   `json:"livecomment"`
+	Score       float64       `json:"score"`
+	Comment     SearchSnippet `json:"comment"`
+}
+
+// 検索語の前後を切り出した本文と、その中で検索語に一致した位置
+type SearchSnippet struct {
+	Text       string            `json:"text"`
+	Highlights []SearchHighlight `json:"highlights"`
+}
+
+// Textの中の位置 (文字単位、Endは含まない)
+type SearchHighlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// 関連度と一緒に取得した配信・ライブコメント
+type livestreamSearchHitRow struct {
+	LivestreamModel
+	Score float64 `db:"score"`
+}
+
+type livecommentSearchHitRow struct {
+	LivecommentModel
+	Score float64 `db:"score"`
+}
+
+// 配信・ライブコメントの全文検索API
+// 空白 (全角空白も含む) で区切った語をすべて含むものを、関連度の高い順に返す
+// GET /api/search?q=&type=livecomment&livestream_id=&limit=
+func searchHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	terms := parseSearchTerms(c.QueryParam("q"))
+	if len(terms) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "q query parameter is required")
+	}
+
+	searchType := c.QueryParam("type")
+	if searchType != "" && searchType != searchTypeLivestream && searchType != searchTypeLivecomment {
+		return echo.NewHTTPError(http.StatusBadRequest, "type must be livestream or livecomment")
+	}
+
+	limit := defaultSearchLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
+		}
+		if n < 1 || n > maxSearchLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be between 1 and "+strconv.Itoa(maxSearchLimit))
+		}
+		limit = n
+	}
+
+	// ライブコメントは配信を指定して絞り込める
+	var livestreamID int64
+	if v := c.QueryParam("livestream_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "livestream_id query parameter must be integer")
+		}
+		livestreamID = n
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	res := SearchResponse{
+		Livestreams:  []LivestreamSearchHit{},
+		Livecomments: []LivecommentSearchHit{},
+	}
+
+	if searchType == "" || searchType == searchTypeLivestream {
+		score, scoreArgs, cond, condArgs := fullTextSearchQuery([]string{"title", "description"}, terms)
+		query := "SELECT *, " + score + " AS score FROM livestreams WHERE " + cond + " ORDER BY score DESC, id DESC LIMIT ?"
+		args := append(append(scoreArgs, condArgs...), limit)
+
+		var rows []*livestreamSearchHitRow
+		if err := tx.SelectContext(ctx, &rows, query, args...); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to search livestreams: "+err.Error())
+		}
+		for _, row := range rows {
+			livestream, err := fillLivestreamResponse(ctx, tx, row.LivestreamModel)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+			}
+			res.Livestreams = append(res.Livestreams, LivestreamSearchHit{
+				Livestream:  livestream,
+				Score:       row.Score,
+				Title:       buildSearchSnippet(row.Title, terms),
+				Description: buildSearchSnippet(row.Description, terms),
+			})
+		}
+	}
+
+	if searchType == "" || searchType == searchTypeLivecomment {
+		score, scoreArgs, cond, condArgs := fullTextSearchQuery([]string{"comment"}, terms)
+		// 非表示にされたライブコメントは検索結果にも出さない
+		query := "SELECT *, " + score + " AS score FROM livecomments WHERE hidden_at IS NULL AND " + cond
+		args := append(scoreArgs, condArgs...)
+		if livestreamID != 0 {
+			query += " AND livestream_id = ?"
+			args = append(args, livestreamID)
+		}
+		query += " ORDER BY score DESC, id DESC LIMIT ?"
+		args = append(args, limit)
+
+		var rows []*livecommentSearchHitRow
+		if err := tx.SelectContext(ctx, &rows, query, args...); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to search livecomments: "+err.Error())
+		}
+		for _, row := range rows {
+			livecomment, err := fillLivecommentResponse(ctx, tx, row.LivecommentModel)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
+			}
+			res.Livecomments = append(res.Livecomments, LivecommentSearchHit{
+				Livecomment: livecomment,
+				Score:       row.Score,
+				Comment:     buildSearchSnippet(row.Comment, terms),
+			})
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// parseSearchTerms は検索文字列を空白で区切る。フレーズ検索の区切りと紛らわしい"は取り除く
+func parseSearchTerms(q string) []string {
+	var terms []string
+	for _, term := range strings.Fields(strings.ReplaceAll(q, `"`, " ")) {
+		if !slices.Contains(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// fullTextSearchQuery は関連度の式と、すべての語を含む行に絞る条件を返す
+// ngramのFULLTEXTインデックスで引けない短い語はLIKEで絞る (関連度には含めない)
+func fullTextSearchQuery(columns []string, terms []string) (string, []interface{}, string, []interface{}) {
+	var (
+		against []string
+		conds   []string
+		args    []interface{}
+	)
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) >= ngramTokenSize {
+			// 語をフレーズとして扱い、ngramの並びごと一致させる
+			against = append(against, `+"`+term+`"`)
+			continue
+		}
+		likes := make([]string, len(columns))
+		for i, column := range columns {
+			likes[i] = column + " LIKE ?"
+			args = append(args, "%"+escapeLikePattern(term)+"%")
+		}
+		conds = append(conds, "("+strings.Join(likes, " OR ")+")")
+	}
+
+	if len(against) == 0 {
+		return "0", nil, strings.Join(conds, " AND "), args
+	}
+	match := "MATCH (" + strings.Join(columns, ", ") + ") AGAINST (? IN BOOLEAN MODE)"
+	booleanQuery := strings.Join(against, " ")
+	conds = append([]string{match}, conds...)
+	return match, []interface{}{booleanQuery}, strings.Join(conds, " AND "), append([]interface{}{booleanQuery}, args...)
+}
+
+// buildSearchSnippet は最初に一致した語の周辺を切り出し、一致した位置を返す
+func buildSearchSnippet(text string, terms []string) SearchSnippet {
+	runes := []rune(text)
+
+	var matches []SearchHighlight
+	for _, term := range terms {
+		t := []rune(term)
+		for i := 0; i+len(t) <= len(runes); i++ {
+			if slices.Equal(runes[i:i+len(t)], t) {
+				matches = append(matches, SearchHighlight{Start: i, End: i + len(t)})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Start < matches[j].Start
+	})
+	// 重なった一致はまとめる
+	var merged []SearchHighlight
+	for _, m := range matches {
+		if n := len(merged); n > 0 && m.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, m.End)
+			continue
+		}
+		merged = append(merged, m)
+	}
+
+	start, end := 0, len(runes)
+	if len(runes) > maxSearchSnippetLength {
+		if len(merged) > 0 {
+			// 一致した語の前にも少し文脈を残す
+			start = max(0, merged[0].Start-maxSearchSnippetLength/4)
+		}
+		end = min(len(runes), start+maxSearchSnippetLength)
+		start = max(0, end-maxSearchSnippetLength)
+	}
+
+	var sb strings.Builder
+	offset := 0
+	if start > 0 {
+		sb.WriteString("…")
+		offset = 1
+	}
+	sb.WriteString(string(runes[start:end]))
+	if end < len(runes) {
+		sb.WriteString("…")
+	}
+
+	highlights := []SearchHighlight{}
+	for _, m := range merged {
+		if m.End <= start || m.Start >= end {
+			continue
+		}
+		highlights = append(highlights, SearchHighlight{
+			Start: max(m.Start, start) - start + offset,
+			End:   min(m.End, end) - start + offset,
+		})
+	}
+
+	return SearchSnippet{
+		Text:       sb.String(),
+		Highlights: highlights,
+	}
+}
@@ -154,9 +154,9 @@ func getUserStatisticsHandler(c echo.Context) error {
 			COUNT(r.id) + IFNULL(SUM(l2.tip), 0) as score
 		FROM
 			users u
-			LEFT JOIN livestreams l ON l.user_id = u.id
-			LEFT JOIN reactions r ON r.livestream_id = l.id
-			LEFT JOIN livecomments l2 ON l2.livestream_id = l.id
+			LEFT JOIN ` + userLivestreamsQuery + ` l ON l.user_id = u.id
+			LEFT JOIN reactions r ON r.livestream_id = l.livestream_id
+			LEFT JOIN livecomments l2 ON l2.livestream_id = l.livestream_id
 		GROUP BY u.id, u.name
 	),
 	ranked_users AS (
@@ -175,8 +175,8 @@ func getUserStatisticsHandler(c echo.Context) error {
 	// リアクション数
 	var totalReactions int64
 	query = `SELECT COUNT(*) FROM users u 
-    INNER JOIN livestreams l ON l.user_id = u.id 
-    INNER JOIN reactions r ON r.livestream_id = l.id
+    INNER JOIN ` + userLivestreamsQuery + ` l ON l.user_id = u.id 
+    INNER JOIN reactions r ON r.livestream_id = l.livestream_id
     WHERE u.name = ?
 	`
 	if err := tx.GetContext(ctx, &totalReactions, query, username); err != nil && !errors.Is(err, sql.ErrNoRows) {
@@ -187,7 +187,8 @@ func getUserStatisticsHandler(c echo.Context) error {
 	var totalLivecomments int64
 	var totalTip int64
 	var livestreams []*LivestreamModel
-	if err := tx.SelectContext(ctx, &livestreams, "SELECT * FROM livestreams WHERE user_id = ?", user.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+	// コラボレーターとして参加した配信も含める
+	if err := tx.SelectContext(ctx, &livestreams, "SELECT * FROM livestreams WHERE user_id = ? OR id IN (SELECT livestream_id FROM livestream_collaborators WHERE user_id = ? AND status = ?)", user.ID, user.ID, collaboratorStatusAccepted); err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
 	}
 
@@ -218,8 +219,8 @@ func getUserStatisticsHandler(c echo.Context) error {
 	query = `
 	SELECT r.emoji_name
 	FROM users u
-	INNER JOIN livestreams l ON l.user_id = u.id
-	INNER JOIN reactions r ON r.livestream_id = l.id
+	INNER JOIN ` + userLivestreamsQuery + ` l ON l.user_id = u.id
+	INNER JOIN reactions r ON r.livestream_id = l.livestream_id
 	WHERE u.name = ?
 	GROUP BY emoji_name
 	ORDER BY COUNT(*) DESC, emoji_name DESC
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// 配信者の全配信に適用するNGワードは、ng_words.livestream_idをこの値にして登録する
+const streamerNGWordLivestreamID = 0
+
+type UpdateStreamerNGWordResponse struct {
+	NGWord *NGWord `json:"ng_word"`
+	// 配信IDごとの、新たに非表示にしたライブコメント
+	HiddenLivecommentIDs map[int64][]int64 `json:"hidden_livecomment_ids"`
+	// restore_livecomments=trueのとき、配信IDごとの元に戻したライブコメント
+	RestoredLivecommentIDs map[int64][]int64 `json:"restored_livecomment_ids"`
+}
+
+// 配信者の全配信向けNGワード一覧取得API
+// GET /api/user/me/ngwords
+func getStreamerNGWordsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	ngWords := []*NGWord{}
+	if err := tx.SelectContext(ctx, &ngWords, "SELECT * FROM ng_words WHERE user_id = ? AND livestream_id = ? ORDER BY created_at DESC", userID, streamerNGWordLivestreamID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, ngWords)
+}
+
+// 配信者の全配信向けNGワード登録API
+// POST /api/user/me/ngwords
+func postStreamerNGWordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *ModerateRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.MatchType == "" {
+		req.MatchType = ngWordMatchTypeSubstring
+	}
+	if !isValidNGWordMatchType(req.MatchType) {
+		return echo.NewHTTPError(http.StatusBadRequest, "match_type must be one of substring, whole_word, regex, glob")
+	}
+
+	ngword := &NGWord{
+		UserID:       userID,
+		LivestreamID: streamerNGWordLivestreamID,
+		Word:         req.NGWord,
+		MatchType:    req.MatchType,
+		CreatedBy:    &userID,
+		CreatedAt:    time.Now().Unix(),
+	}
+	// 配信ごとの厳しさで判定が変わるので、一番厳しい正規化でも壊れないことを確かめておく
+	if _, err := compileNGWordRule(ngword, ngWordStrictnessStrict); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid NG word: "+err.Error())
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO ng_words(user_id, livestream_id, word, match_type, created_by, created_at) VALUES (:user_id, :livestream_id, :word, :match_type, :created_by, :created_at)", ngword)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert new NG word: "+err.Error())
+	}
+	wordID, err := rs.LastInsertId()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted NG word id: "+err.Error())
+	}
+	ngword.ID = wordID
+	if err := recordModerationEvent(ctx, tx, userID, streamerModerationLivestreamID, userID, moderationActionNGWordAdded, moderationTargetNGWord, ngword.ID, map[string]interface{}{
+		"word":       ngword.Word,
+		"match_type": ngword.MatchType,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+
+	hiddenLivecommentIDs, err := hideLivecommentsMatchingStreamerNGWord(ctx, tx, ngword)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide old livecomments that hit spams: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	ngWordMatchers.invalidateUser(userID)
+	publishStreamerNGWordModeration(wordID, hiddenLivecommentIDs, nil)
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"word_id": wordID,
+	})
+}
+
+// 配信者の全配信向けNGワード編集API
+// PATCH /api/user/me/ngwords/:word_id
+func patchStreamerNGWordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	wordID, err := strconv.Atoi(c.Param("word_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "word_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *PatchNGWordRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.MatchType != nil && !isValidNGWordMatchType(*req.MatchType) {
+		return echo.NewHTTPError(http.StatusBadRequest, "match_type must be one of substring, whole_word, regex, glob")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	ngword, err := getStreamerNGWord(ctx, tx, int64(wordID), userID)
+	if err != nil {
+		return err
+	}
+
+	if req.NGWord != nil {
+		ngword.Word = *req.NGWord
+	}
+	if req.MatchType != nil {
+		ngword.MatchType = *req.MatchType
+	}
+	if _, err := compileNGWordRule(ngword, ngWordStrictnessStrict); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid NG word: "+err.Error())
+	}
+
+	if _, err := tx.NamedExecContext(ctx, "UPDATE ng_words SET word = :word, match_type = :match_type WHERE id = :id", ngword); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update NG word: "+err.Error())
+	}
+	if err := recordModerationEvent(ctx, tx, userID, streamerModerationLivestreamID, userID, moderationActionNGWordUpdated, moderationTargetNGWord, ngword.ID, map[string]interface{}{
+		"word":       ngword.Word,
+		"match_type": ngword.MatchType,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+
+	restoredLivecommentIDs := map[int64][]int64{}
+	if c.QueryParam("restore_livecomments") == "true" {
+		restoredLivecommentIDs, err = restoreLivecommentsHiddenByStreamerNGWord(ctx, tx, ngword)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore livecomments: "+err.Error())
+		}
+	}
+
+	hiddenLivecommentIDs, err := hideLivecommentsMatchingStreamerNGWord(ctx, tx, ngword)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide old livecomments that hit spams: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	ngWordMatchers.invalidateUser(userID)
+	publishStreamerNGWordModeration(ngword.ID, hiddenLivecommentIDs, restoredLivecommentIDs)
+
+	return c.JSON(http.StatusOK, &UpdateStreamerNGWordResponse{
+		NGWord:                 ngword,
+		HiddenLivecommentIDs:   hiddenLivecommentIDs,
+		RestoredLivecommentIDs: restoredLivecommentIDs,
+	})
+}
+
+// 配信者の全配信向けNGワード削除API
+// DELETE /api/user/me/ngwords/:word_id
+func deleteStreamerNGWordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	wordID, err := strconv.Atoi(c.Param("word_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "word_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	ngword, err := getStreamerNGWord(ctx, tx, int64(wordID), userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ng_words WHERE id = ?", ngword.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete NG word: "+err.Error())
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ng_word_exclusions WHERE ng_word_id = ?", ngword.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete NG word exclusions: "+err.Error())
+	}
+	if err := recordModerationEvent(ctx, tx, userID, streamerModerationLivestreamID, userID, moderationActionNGWordRemoved, moderationTargetNGWord, ngword.ID, map[string]interface{}{
+		"word":       ngword.Word,
+		"match_type": ngword.MatchType,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+
+	restoredLivecommentIDs := map[int64][]int64{}
+	if c.QueryParam("restore_livecomments") == "true" {
+		restoredLivecommentIDs, err = restoreLivecommentsHiddenByStreamerNGWord(ctx, tx, ngword)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore livecomments: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	ngWordMatchers.invalidateUser(userID)
+	publishStreamerNGWordModeration(ngword.ID, nil, restoredLivecommentIDs)
+
+	return c.JSON(http.StatusOK, &UpdateStreamerNGWordResponse{
+		NGWord:                 ngword,
+		HiddenLivecommentIDs:   map[int64][]int64{},
+		RestoredLivecommentIDs: restoredLivecommentIDs,
+	})
+}
+
+// 配信者の全配信向けNGワードを、この配信では適用しないようにするAPI
+// PUT /api/livestream/:livestream_id/ngwords/:word_id/exclusion
+func excludeStreamerNGWordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	wordID, err := strconv.Atoi(c.Param("word_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "word_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, ngword, err := getOwnedLivestreamAndStreamerNGWord(ctx, tx, int64(livestreamID), int64(wordID), userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT IGNORE INTO ng_word_exclusions (livestream_id, ng_word_id, created_at) VALUES (?, ?, ?)", livestreamModel.ID, ngword.ID, time.Now().Unix()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert NG word exclusion: "+err.Error())
+	}
+	if err := recordModerationEvent(ctx, tx, livestreamModel.UserID, livestreamModel.ID, userID, moderationActionNGWordExcluded, moderationTargetNGWord, ngword.ID, map[string]interface{}{
+		"word":       ngword.Word,
+		"match_type": ngword.MatchType,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+
+	var restoredLivecommentIDs []int64
+	if c.QueryParam("restore_livecomments") == "true" {
+		restoredLivecommentIDs, err = restoreLivecommentsHiddenByNGWord(ctx, tx, livestreamModel, ngword.ID, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore livecomments: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	ngWordMatchers.invalidate(livestreamModel.ID)
+	publishNGWordModeration(livestreamModel.ID, ngword.ID, nil, restoredLivecommentIDs)
+
+	return c.JSON(http.StatusOK, &UpdateNGWordResponse{
+		NGWord:                 ngword,
+		HiddenLivecommentIDs:   []int64{},
+		RestoredLivecommentIDs: nonNilIDs(restoredLivecommentIDs),
+	})
+}
+
+// 除外していた配信者の全配信向けNGワードを、この配信でも再び適用するAPI
+// DELETE /api/livestream/:livestream_id/ngwords/:word_id/exclusion
+func includeStreamerNGWordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	wordID, err := strconv.Atoi(c.Param("word_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "word_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, ngword, err := getOwnedLivestreamAndStreamerNGWord(ctx, tx, int64(livestreamID), int64(wordID), userID)
+	if err != nil {
+		return err
+	}
+
+	rs, err := tx.ExecContext(ctx, "DELETE FROM ng_word_exclusions WHERE livestream_id = ? AND ng_word_id = ?", livestreamModel.ID, ngword.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete NG word exclusion: "+err.Error())
+	}
+	if n, err := rs.RowsAffected(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get affected rows: "+err.Error())
+	} else if n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "NG word is not excluded from this livestream")
+	}
+	if err := recordModerationEvent(ctx, tx, livestreamModel.UserID, livestreamModel.ID, userID, moderationActionNGWordIncluded, moderationTargetNGWord, ngword.ID, map[string]interface{}{
+		"word":       ngword.Word,
+		"match_type": ngword.MatchType,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to record moderation event: "+err.Error())
+	}
+
+	settingsModel, err := getLivestreamSettings(ctx, tx, livestreamModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream settings: "+err.Error())
+	}
+	hiddenLivecommentIDs, err := hideLivecommentsMatchingNGWord(ctx, tx, livestreamModel.ID, ngword, settingsModel.NGWordStrictness, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide old livecomments that hit spams: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	ngWordMatchers.invalidate(livestreamModel.ID)
+	publishNGWordModeration(livestreamModel.ID, ngword.ID, hiddenLivecommentIDs, nil)
+
+	return c.JSON(http.StatusOK, &UpdateNGWordResponse{
+		NGWord:                 ngword,
+		HiddenLivecommentIDs:   nonNilIDs(hiddenLivecommentIDs),
+		RestoredLivecommentIDs: []int64{},
+	})
+}
+
+// getStreamerNGWord は配信者自身の全配信向けNGワードを更新用にロックして取得する
+func getStreamerNGWord(ctx context.Context, tx *sqlx.Tx, wordID int64, userID int64) (*NGWord, error) {
+	var ngword NGWord
+	if err := tx.GetContext(ctx, &ngword, "SELECT * FROM ng_words WHERE id = ? AND user_id = ? AND livestream_id = ? FOR UPDATE", wordID, userID, streamerNGWordLivestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "NG word not found")
+		} else {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG word: "+err.Error())
+		}
+	}
+	return &ngword, nil
+}
+
+func getOwnedLivestreamAndStreamerNGWord(ctx context.Context, tx *sqlx.Tx, livestreamID int64, wordID int64, userID int64) (LivestreamModel, *NGWord, error) {
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LivestreamModel{}, nil, echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return LivestreamModel{}, nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+	// 配信者自身の配信に対するmoderateなのかを検証
+	if livestreamModel.UserID != userID {
+		return LivestreamModel{}, nil, echo.NewHTTPError(http.StatusBadRequest, "A streamer can't moderate livestreams that other streamers own")
+	}
+
+	ngword, err := getStreamerNGWord(ctx, tx, wordID, userID)
+	if err != nil {
+		return LivestreamModel{}, nil, err
+	}
+	return livestreamModel, ngword, nil
+}
+
+// hideLivecommentsMatchingStreamerNGWord は配信者の全配信のうち、NGワードを除外していない配信の過去のライブコメントを非表示にする
+// 判定の厳しさは配信ごとの設定に従う。各配信のライブコメントはhideLivecommentsMatchingNGWordでidの順に区切って照合する
+func hideLivecommentsMatchingStreamerNGWord(ctx context.Context, tx *sqlx.Tx, ngword *NGWord) (map[int64][]int64, error) {
+	var livestreamModels []LivestreamModel
+	query := "SELECT * FROM livestreams WHERE user_id = ? AND id NOT IN (SELECT livestream_id FROM ng_word_exclusions WHERE ng_word_id = ?) ORDER BY id"
+	if err := tx.SelectContext(ctx, &livestreamModels, query, ngword.UserID, ngword.ID); err != nil {
+		return nil, err
+	}
+	if len(livestreamModels) == 0 {
+		return map[int64][]int64{}, nil
+	}
+
+	// 正規化せずに判定する配信では、LIKEに当たるライブコメントがない配信を照合せずに済ませる
+	var candidateLivestreamIDs map[int64]struct{}
+	if likePattern, ok := ngWordLikePrefilter(ngword, ngWordStrictnessExact); ok {
+		livestreamIDs := make([]int64, len(livestreamModels))
+		for i, livestreamModel := range livestreamModels {
+			livestreamIDs[i] = livestreamModel.ID
+		}
+		query, params, err := sqlx.In("SELECT DISTINCT livestream_id FROM livecomments WHERE livestream_id IN (?) AND hidden_at IS NULL AND comment LIKE ?", livestreamIDs, likePattern)
+		if err != nil {
+			return nil, err
+		}
+		var ids []int64
+		if err := tx.SelectContext(ctx, &ids, tx.Rebind(query), params...); err != nil {
+			return nil, err
+		}
+		candidateLivestreamIDs = make(map[int64]struct{}, len(ids))
+		for _, id := range ids {
+			candidateLivestreamIDs[id] = struct{}{}
+		}
+	}
+
+	hiddenLivecommentIDs := map[int64][]int64{}
+	for _, livestreamModel := range livestreamModels {
+		settingsModel, err := getLivestreamSettings(ctx, tx, livestreamModel.ID)
+		if err != nil {
+			return nil, err
+		}
+		if candidateLivestreamIDs != nil && settingsModel.NGWordStrictness == ngWordStrictnessExact {
+			if _, ok := candidateLivestreamIDs[livestreamModel.ID]; !ok {
+				continue
+			}
+		}
+		livecommentIDs, err := hideLivecommentsMatchingNGWord(ctx, tx, livestreamModel.ID, ngword, settingsModel.NGWordStrictness, ngword.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if len(livecommentIDs) > 0 {
+			hiddenLivecommentIDs[livestreamModel.ID] = livecommentIDs
+		}
+	}
+	return hiddenLivecommentIDs, nil
+}
+
+// restoreLivecommentsHiddenByStreamerNGWord はNGワードで非表示にしたライブコメントを、配信ごとに判定し直す
+// 非表示にしたライブコメントがある配信だけを、idの順に区切って照合する
+func restoreLivecommentsHiddenByStreamerNGWord(ctx context.Context, tx *sqlx.Tx, ngword *NGWord) (map[int64][]int64, error) {
+	var livestreamModels []LivestreamModel
+	query := "SELECT * FROM livestreams WHERE user_id = ? AND id IN (SELECT livestream_id FROM livecomments WHERE hidden_reason = ? AND hidden_ng_word_id = ?) ORDER BY id"
+	if err := tx.SelectContext(ctx, &livestreamModels, query, ngword.UserID, livecommentHiddenReasonNGWord, ngword.ID); err != nil {
+		return nil, err
+	}
+
+	restoredLivecommentIDs := map[int64][]int64{}
+	for _, livestreamModel := range livestreamModels {
+		livecommentIDs, err := restoreLivecommentsHiddenByNGWord(ctx, tx, livestreamModel, ngword.ID, ngword.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if len(livecommentIDs) > 0 {
+			restoredLivecommentIDs[livestreamModel.ID] = livecommentIDs
+		}
+	}
+	return restoredLivecommentIDs, nil
+}
+
+func publishStreamerNGWordModeration(wordID int64, hiddenLivecommentIDs map[int64][]int64, restoredLivecommentIDs map[int64][]int64) {
+	for livestreamID, livecommentIDs := range restoredLivecommentIDs {
+		publishNGWordModeration(livestreamID, wordID, nil, livecommentIDs)
+	}
+	for livestreamID, livecommentIDs := range hiddenLivecommentIDs {
+		publishNGWordModeration(livestreamID, wordID, livecommentIDs, nil)
+	}
+}
@@ -10,6 +10,16 @@ TRUNCATE TABLE livestream_tags;
 TRUNCATE TABLE livecomments;
 TRUNCATE TABLE livestreams;
 TRUNCATE TABLE users;
+TRUNCATE TABLE livestream_settings;
+TRUNCATE TABLE ng_word_exclusions;
+TRUNCATE TABLE livecomment_review_items;
+TRUNCATE TABLE livestream_moderators;
+TRUNCATE TABLE user_bans;
+TRUNCATE TABLE moderation_events;
+TRUNCATE TABLE reservation_terms;
+TRUNCATE TABLE reservation_waitlist;
+TRUNCATE TABLE notifications;
+TRUNCATE TABLE livestream_collaborators;
 
 ALTER TABLE `themes` auto_increment = 1;
 ALTER TABLE `icons` auto_increment = 1;
@@ -22,4 +32,7 @@ ALTER TABLE `reactions` auto_increment = 1;
 ALTER TABLE `tags` auto_increment = 1;
 ALTER TABLE `livecomments` auto_increment = 1;
 ALTER TABLE `livestreams` auto_increment = 1;
-ALTER TABLE `users` auto_increment = 1;
\ No newline at end of file
+ALTER TABLE `users` auto_increment = 1;
+
+-- 2023/11/25 10:00からの１年間を1時間ごと・5枠で予約できるようにする
+INSERT INTO reservation_terms (start_at, end_at, slot_seconds, capacity, created_at) VALUES (1700874000, 1732496400, 3600, 5, UNIX_TIMESTAMP());
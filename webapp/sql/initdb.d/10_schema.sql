@@ -33,8 +33,15 @@ CREATE TABLE `livestreams` (
   `playlist_url` VARCHAR(255) NOT NULL,
   `thumbnail_url` VARCHAR(255) NOT NULL,
   `start_at` BIGINT NOT NULL,
-  `end_at` BIGINT NOT NULL
+  `end_at` BIGINT NOT NULL,
+  -- 配信の状態 (scheduled, live, ended, cancelled)
+  -- NULLは状態を持たない初期データで、start_at/end_atから状態を判断する
+  `status` VARCHAR(16) NULL,
+  -- 更新のたびに増やす。ETagとして返し、If-Matchで楽観的排他制御に使う
+  `version` BIGINT NOT NULL DEFAULT 1
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+-- 全文検索用。ngramなので日本語も分かち書きせずに引ける。INSERT・UPDATEで自動的に更新される
+CREATE FULLTEXT INDEX livestreams_fulltext ON livestreams(`title`, `description`) WITH PARSER ngram;
 
 -- ライブ配信予約枠
 CREATE TABLE `reservation_slots` (
@@ -44,6 +51,18 @@ CREATE TABLE `reservation_slots` (
   `end_at` BIGINT NOT NULL
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 
+-- ライブ配信を予約できる期間と、予約枠の区切り方
+CREATE TABLE `reservation_terms` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `start_at` BIGINT NOT NULL,
+  `end_at` BIGINT NOT NULL,
+  -- 予約枠1つあたりの秒数
+  `slot_seconds` BIGINT NOT NULL,
+  -- 予約枠を生成するときの、1枠あたりの同時配信数
+  `capacity` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
 -- ライブストリームに付与される、サービスで定義されたタグ
 CREATE TABLE `tags` (
   `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
@@ -73,8 +92,18 @@ CREATE TABLE `livecomments` (
   `livestream_id` BIGINT NOT NULL,
   `comment` VARCHAR(255) NOT NULL,
   `tip` BIGINT NOT NULL DEFAULT 0,
-  `created_at` BIGINT NOT NULL
+  `created_at` BIGINT NOT NULL,
+  -- モデレーションで非表示にされた日時 (投げ銭の集計に残すため削除はしない)
+  `hidden_at` BIGINT NULL DEFAULT NULL,
+  -- 非表示にした理由 (ng_word, reports, moderator)
+  `hidden_reason` VARCHAR(32) NULL DEFAULT NULL,
+  -- NGワードで非表示にした場合、そのNGワードのID
+  `hidden_ng_word_id` BIGINT NULL DEFAULT NULL,
+  -- 非表示にした配信者またはモデレーター (NGワードの登録や編集によるものも含む)。通報による自動非表示ではNULL
+  `hidden_by` BIGINT NULL DEFAULT NULL
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+-- 全文検索用 (livestreams_fulltextと同じくngram)
+CREATE FULLTEXT INDEX livecomments_fulltext ON livecomments(`comment`) WITH PARSER ngram;
 
 -- ユーザからのライブコメントのスパム報告
 CREATE TABLE `livecomment_reports` (
@@ -82,15 +111,29 @@ CREATE TABLE `livecomment_reports` (
   `user_id` BIGINT NOT NULL,
   `livestream_id` BIGINT NOT NULL,
   `livecomment_id` BIGINT NOT NULL,
-  `created_at` BIGINT NOT NULL
+  -- 通報者の信頼度による重み。しきい値をスコアで判定するときに使う
+  `weight` DOUBLE NOT NULL DEFAULT 1,
+  `created_at` BIGINT NOT NULL,
+  -- 対応状況 (open, dismissed, actioned)
+  `status` VARCHAR(16) NOT NULL DEFAULT 'open',
+  `resolved_at` BIGINT NULL,
+  -- 対応した配信者またはモデレーター
+  `resolved_by` BIGINT NULL,
+  UNIQUE `uniq_livecomment_reports_livecomment_user` (`livecomment_id`, `user_id`),
+  INDEX `livecomment_reports_livestream_id_status` (`livestream_id`, `status`)
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 
 -- 配信者からのNGワード登録
 CREATE TABLE `ng_words` (
   `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
   `user_id` BIGINT NOT NULL,
+  -- 0のときは配信者の全配信に適用する
   `livestream_id` BIGINT NOT NULL,
   `word` VARCHAR(255) NOT NULL,
+  -- 照合方法 (substring, whole_word, regex, glob)
+  `match_type` VARCHAR(16) NOT NULL DEFAULT 'substring',
+  -- 登録した配信者またはモデレーター
+  `created_by` BIGINT NULL,
   `created_at` BIGINT NOT NULL
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 CREATE INDEX ng_words_word ON ng_words(`word`);
@@ -103,4 +146,131 @@ CREATE TABLE `reactions` (
   -- :innocent:, :tada:, etc...
   `emoji_name` VARCHAR(255) NOT NULL,
   `created_at` BIGINT NOT NULL
-) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
\ No newline at end of file
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- ライブ配信ごとの配信者による設定
+CREATE TABLE `livestream_settings` (
+  `livestream_id` BIGINT NOT NULL PRIMARY KEY,
+  -- NGワード判定の厳しさ (exact, standard, strict)
+  `ng_word_strictness` VARCHAR(16) NOT NULL DEFAULT 'exact',
+  -- 通報による自動非表示 (none, count, score)
+  `report_threshold_mode` VARCHAR(16) NOT NULL DEFAULT 'none',
+  -- countなら通報したユーザ数、scoreなら通報の重みの合計がこれ以上になったら非表示にする
+  `report_threshold` DOUBLE NOT NULL DEFAULT 0,
+  -- スローモード。同じユーザが続けてライブコメントを投稿するまでに空ける秒数 (0なら無効)
+  `slow_mode_seconds` INT NOT NULL DEFAULT 0
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- 配信者の全配信向けNGワードを、特定の配信では適用しない設定
+CREATE TABLE `ng_word_exclusions` (
+  `livestream_id` BIGINT NOT NULL,
+  `ng_word_id` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  PRIMARY KEY (`livestream_id`, `ng_word_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- 自動で非表示にしたライブコメントの、配信者による確認待ち
+CREATE TABLE `livecomment_review_items` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `livestream_id` BIGINT NOT NULL,
+  `livecomment_id` BIGINT NOT NULL,
+  `reason` VARCHAR(16) NOT NULL,
+  -- 非表示にした時点の通報数またはスコア
+  `score` DOUBLE NOT NULL,
+  -- pending, kept, restored
+  `status` VARCHAR(16) NOT NULL DEFAULT 'pending',
+  `created_at` BIGINT NOT NULL,
+  `resolved_at` BIGINT NULL,
+  -- 確認した配信者またはモデレーター
+  `resolved_by` BIGINT NULL
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+CREATE INDEX livecomment_review_items_livestream_id ON livecomment_review_items(`livestream_id`, `status`);
+
+-- 配信者から権限を委譲されたモデレーター
+CREATE TABLE `livestream_moderators` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  -- 権限を委譲した配信者
+  `streamer_id` BIGINT NOT NULL,
+  -- 0のときは配信者の全配信のモデレーター
+  `livestream_id` BIGINT NOT NULL,
+  `user_id` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  UNIQUE `uniq_livestream_moderators` (`streamer_id`, `livestream_id`, `user_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- 配信者によるユーザのBAN・タイムアウト
+CREATE TABLE `user_bans` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `streamer_id` BIGINT NOT NULL,
+  -- 0のときは配信者の全配信からのBAN
+  `livestream_id` BIGINT NOT NULL,
+  `user_id` BIGINT NOT NULL,
+  `reason` VARCHAR(255) NOT NULL DEFAULT '',
+  -- タイムアウトの期限。NULLなら無期限のBAN
+  `expires_at` BIGINT NULL,
+  -- BANした配信者またはモデレーター
+  `created_by` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  UNIQUE `uniq_user_bans` (`streamer_id`, `livestream_id`, `user_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- モデレーション操作の記録 (追記のみ)
+CREATE TABLE `moderation_events` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `streamer_id` BIGINT NOT NULL,
+  -- 配信者の全配信に対する操作なら0
+  `livestream_id` BIGINT NOT NULL,
+  -- 操作した配信者またはモデレーター。通報による自動非表示などではNULL
+  `actor_id` BIGINT NULL,
+  -- ng_word_added, livecomment_hidden, user_banned, report_resolved, etc...
+  `action` VARCHAR(32) NOT NULL,
+  `target_type` VARCHAR(32) NOT NULL,
+  `target_id` BIGINT NOT NULL,
+  -- 操作の詳細 (JSON)
+  `detail` TEXT NOT NULL,
+  `created_at` BIGINT NOT NULL
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+CREATE INDEX moderation_events_streamer_id ON moderation_events(`streamer_id`, `livestream_id`, `created_at`);
+
+-- 予約枠が埋まっていた予約のキャンセル待ち
+CREATE TABLE `reservation_waitlist` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `user_id` BIGINT NOT NULL,
+  -- 予約時のリクエスト (JSON)。繰り上げ時にこの内容で配信を予約する
+  `request` TEXT NOT NULL,
+  `start_at` BIGINT NOT NULL,
+  `end_at` BIGINT NOT NULL,
+  -- waiting, promoted, cancelled, expired
+  `status` VARCHAR(16) NOT NULL,
+  -- 繰り上げで予約した配信
+  `livestream_id` BIGINT NULL,
+  `created_at` BIGINT NOT NULL,
+  `promoted_at` BIGINT NULL,
+  INDEX `reservation_waitlist_status_start_at` (`status`, `start_at`),
+  INDEX `reservation_waitlist_user_id` (`user_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- ユーザ宛ての通知
+CREATE TABLE `notifications` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `user_id` BIGINT NOT NULL,
+  `type` VARCHAR(32) NOT NULL,
+  -- 通知の内容 (JSON)
+  `data` TEXT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  `read_at` BIGINT NULL,
+  INDEX `notifications_user_id_created_at` (`user_id`, `created_at`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- 配信のコラボレーター (共同配信者)。招待を承諾するまでは配信に表示しない
+CREATE TABLE `livestream_collaborators` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `livestream_id` BIGINT NOT NULL,
+  `user_id` BIGINT NOT NULL,
+  -- invited, accepted, declined
+  `status` VARCHAR(16) NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  `responded_at` BIGINT NULL,
+  UNIQUE `uniq_livestream_collaborators` (`livestream_id`, `user_id`),
+  INDEX `livestream_collaborators_user_id` (`user_id`, `status`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
@@ -2000,3 +2000,6 @@ INSERT INTO themes (user_id, dark_mode) VALUES (999, false);
 INSERT INTO users (id, name, display_name, description, password) VALUES (1000, 'tomoya450', 'おまんまる', '普段脚本家をしています。\nよろしくおねがいします！\n\n連絡は以下からお願いします。\n\nウェブサイト: http://tomoya45.example.com/\nメールアドレス: tomoya45@example.com\n', '$2a$04$/v16fIbxYBiHvtEmtjgydeJ/fUI2H0OhCgNdTReh5WZUtHYvubDDi');
 INSERT INTO themes (user_id, dark_mode) VALUES (1000, false);
 
+-- NOTE: 予約期間・予約枠の管理APIを使うサービス運営者。登録APIでは作れない名前なのでここで作る。パスワードは `test`
+INSERT INTO users (id, name, display_name, description, password) VALUES (1001, 'pipe', 'ISUPipe運営', 'サービス運営用', '$2a$04$LBt4Dc0Uu3HE0c.8KVMtbOnXwd4PHCboGxa2I57RmJFQVba/B0U8a');
+INSERT INTO themes (user_id, dark_mode) VALUES (1001, false);